@@ -0,0 +1,59 @@
+/*
+已有数据库升级：给slb_svr和slb_policy表增加新字段，字段定义和slb_svr.sql、slb_policy.sql一致。
+新建数据库直接导入slb_svr.sql和slb_policy.sql，不需要执行此脚本。
+每段对应一次升级，按顺序执行还没有执行过的段；MySQL 5.6不支持ADD COLUMN IF NOT EXISTS，已经执行过的段不要重复执行。
+旧数据库没有升级时，配置服务读取slb_svr失败，不会下发服务器配置；engine读取slb_policy失败，不会加载用户策略。
+*/
+
+SET FOREIGN_KEY_CHECKS=0;
+
+-- ----------------------------
+-- slb_svr：H264/H265转码能力
+-- ----------------------------
+ALTER TABLE `slb_svr`
+  ADD COLUMN `h264capacity` int(11) NOT NULL DEFAULT '0' COMMENT 'H264可同时转码的任务数，0表示不处理H264',
+  ADD COLUMN `h265capacity` int(11) NOT NULL DEFAULT '0' COMMENT 'H265可同时转码的任务数，0表示不处理H265';
+
+-- ----------------------------
+-- slb_svr：慢启动
+-- ----------------------------
+ALTER TABLE `slb_svr`
+  ADD COLUMN `slowstart` int(11) NOT NULL DEFAULT '0' COMMENT '从宕机恢复后的慢启动时长，单位秒，0表示使用engine配置的默认值';
+
+-- ----------------------------
+-- slb_svr：过载阈值
+-- ----------------------------
+ALTER TABLE `slb_svr`
+  ADD COLUMN `cpuhigh` int(11) NOT NULL DEFAULT '0' COMMENT 'CPU使用率超过此值暂停分配，0表示使用engine配置的调度池阈值',
+  ADD COLUMN `cpulow` int(11) NOT NULL DEFAULT '0' COMMENT '暂停分配后CPU使用率降到此值恢复',
+  ADD COLUMN `memhigh` int(11) NOT NULL DEFAULT '0' COMMENT '内存使用率超过此值暂停分配，0表示使用engine配置的调度池阈值',
+  ADD COLUMN `memlow` int(11) NOT NULL DEFAULT '0' COMMENT '暂停分配后内存使用率降到此值恢复',
+  ADD COLUMN `iohigh` int(11) NOT NULL DEFAULT '0' COMMENT 'IO等待超过此值暂停分配，0表示使用engine配置的调度池阈值',
+  ADD COLUMN `iolow` int(11) NOT NULL DEFAULT '0' COMMENT '暂停分配后IO等待降到此值恢复';
+
+-- ----------------------------
+-- slb_svr：管理状态
+-- ----------------------------
+ALTER TABLE `slb_svr`
+  ADD COLUMN `adminstate` varchar(16) NOT NULL DEFAULT 'active' COMMENT '管理状态：active 正常，drain 排空，maintenance 维护';
+
+-- ----------------------------
+-- slb_policy：请求速率和并发配额
+-- ----------------------------
+ALTER TABLE `slb_policy`
+  ADD COLUMN `Rate` double NOT NULL DEFAULT '0' COMMENT '每秒最多的请求数，0表示不限制',
+  ADD COLUMN `Burst` int(11) NOT NULL DEFAULT '0' COMMENT '允许的突发请求数，0表示1秒的请求数',
+  ADD COLUMN `MaxConcurrent` int(11) NOT NULL DEFAULT '0' COMMENT '同时进行的最多任务数，0表示不限制';
+
+-- ----------------------------
+-- slb_policy：固定服务器列表
+-- ----------------------------
+ALTER TABLE `slb_policy`
+  MODIFY COLUMN `Ip` varchar(1024) DEFAULT NULL COMMENT '按顺序优先使用的服务器IP，多台以逗号分隔',
+  ADD COLUMN `Fallback` varchar(16) NOT NULL DEFAULT 'fail' COMMENT '指定的服务器都不可用时：fail 回复失败，schedule 正常调度';
+
+-- ----------------------------
+-- slb_policy：公平排队权重
+-- ----------------------------
+ALTER TABLE `slb_policy`
+  ADD COLUMN `Weight` int(11) NOT NULL DEFAULT '0' COMMENT '同一优先级内按用户公平排队的权重，0表示1';
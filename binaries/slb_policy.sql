@@ -22,14 +22,19 @@ DROP TABLE IF EXISTS `slb_policy`;
 CREATE TABLE `slb_policy` (
   `UserID` varchar(255) NOT NULL,
   `Priority` int(11) DEFAULT NULL,
-  `Ip` varchar(255) DEFAULT NULL,
+  `Ip` varchar(1024) DEFAULT NULL COMMENT '按顺序优先使用的服务器IP，多台以逗号分隔',
+  `Fallback` varchar(16) NOT NULL DEFAULT 'fail' COMMENT '指定的服务器都不可用时：fail 回复失败，schedule 正常调度',
+  `Rate` double NOT NULL DEFAULT '0' COMMENT '每秒最多的请求数，0表示不限制',
+  `Burst` int(11) NOT NULL DEFAULT '0' COMMENT '允许的突发请求数，0表示1秒的请求数',
+  `MaxConcurrent` int(11) NOT NULL DEFAULT '0' COMMENT '同时进行的最多任务数，0表示不限制',
+  `Weight` int(11) NOT NULL DEFAULT '0' COMMENT '同一优先级内按用户公平排队的权重，0表示1',
   PRIMARY KEY (`UserID`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 -- ----------------------------
 -- Records of slb_policy
 -- ----------------------------
-INSERT INTO `slb_policy` VALUES ('1111', '6', '3333');
-INSERT INTO `slb_policy` VALUES ('123456', '5', '123456');
-INSERT INTO `slb_policy` VALUES ('2222', '22', '2222');
-INSERT INTO `slb_policy` VALUES ('321', '3', '44');
+INSERT INTO `slb_policy` VALUES ('1111', '6', '3333', 'fail', '0', '0', '0', '0');
+INSERT INTO `slb_policy` VALUES ('123456', '5', '123456', 'fail', '0', '0', '0', '0');
+INSERT INTO `slb_policy` VALUES ('2222', '22', '2222', 'fail', '0', '0', '0', '0');
+INSERT INTO `slb_policy` VALUES ('321', '3', '44', 'fail', '0', '0', '0', '0');
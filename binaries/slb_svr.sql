@@ -29,11 +29,21 @@ CREATE TABLE `slb_svr` (
   `heartbeatInterval` int(11) DEFAULT NULL,
   `retryTime` int(11) DEFAULT NULL,
   `serverport` int(11) DEFAULT NULL,
+  `h264capacity` int(11) NOT NULL DEFAULT '0' COMMENT 'H264可同时转码的任务数，0表示不处理H264',
+  `h265capacity` int(11) NOT NULL DEFAULT '0' COMMENT 'H265可同时转码的任务数，0表示不处理H265',
+  `slowstart` int(11) NOT NULL DEFAULT '0' COMMENT '从宕机恢复后的慢启动时长，单位秒，0表示使用engine配置的默认值',
+  `cpuhigh` int(11) NOT NULL DEFAULT '0' COMMENT 'CPU使用率超过此值暂停分配，0表示使用engine配置的调度池阈值',
+  `cpulow` int(11) NOT NULL DEFAULT '0' COMMENT '暂停分配后CPU使用率降到此值恢复',
+  `memhigh` int(11) NOT NULL DEFAULT '0' COMMENT '内存使用率超过此值暂停分配，0表示使用engine配置的调度池阈值',
+  `memlow` int(11) NOT NULL DEFAULT '0' COMMENT '暂停分配后内存使用率降到此值恢复',
+  `iohigh` int(11) NOT NULL DEFAULT '0' COMMENT 'IO等待超过此值暂停分配，0表示使用engine配置的调度池阈值',
+  `iolow` int(11) NOT NULL DEFAULT '0' COMMENT '暂停分配后IO等待降到此值恢复',
+  `adminstate` varchar(16) NOT NULL DEFAULT 'active' COMMENT '管理状态：active 正常，drain 排空，maintenance 维护',
   PRIMARY KEY (`serverid`)
 ) ENGINE=InnoDB AUTO_INCREMENT=102 DEFAULT CHARSET=utf8;
 
 -- ----------------------------
 -- Records of slb_svr
 -- ----------------------------
-INSERT INTO `slb_svr` VALUES ('1', 'server1', '80', '192.168.59.128', '6', 'cpu', '5000', '3', '100');
-INSERT INTO `slb_svr` VALUES ('2', 'server2', '800', '192.168.59.129', '5', 'cpu', '5000', '3', '203');
+INSERT INTO `slb_svr` VALUES ('1', 'server1', '80', '192.168.59.128', '6', 'cpu', '5000', '3', '100', '16', '4', '0', '0', '0', '0', '0', '0', '0', 'active');
+INSERT INTO `slb_svr` VALUES ('2', 'server2', '800', '192.168.59.129', '5', 'cpu', '5000', '3', '203', '16', '4', '0', '0', '0', '0', '0', '0', '0', 'active');
@@ -0,0 +1,94 @@
+package config
+
+//控制接口认证的签名：engine验证，配置服务和健康检查发送请求时按各自配置文件[auth]的caller和secret签名。
+//签名为 hex(HMAC-SHA256(secret, 方法 + "\n" + 路径 + "\n" + 查询参数 + "\n" + 时间戳 + "\n" + 随机数 + "\n" + body))，
+//查询参数按参数名排序后编码(url.Values.Encode)，没有查询参数时为空；随机数每个请求不同，engine拒绝重复使用的随机数
+import (
+	"common/utils"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AuthCallerHeader    = "X-Slb-Caller"    //调用方
+	AuthKeyHeader       = "X-Slb-Key"       //静态密钥
+	AuthTimestampHeader = "X-Slb-Timestamp" //签名时间，unix秒
+	AuthSignatureHeader = "X-Slb-Signature" //HMAC签名
+	AuthNonceHeader     = "X-Slb-Nonce"     //签名的随机数，同一个随机数只能使用一次
+)
+
+//密钥最短长度，太短的密钥不使用
+const MinAuthSecretLen int = 16
+
+//示例配置中占位密钥的前缀，部署时必须修改
+const AuthSecretPlaceholder string = "change-me"
+
+//密钥是否可以使用：至少16个字符，并且不是示例配置中的占位密钥
+func ValidAuthSecret(secret string) bool {
+	return len(secret) >= MinAuthSecretLen && !strings.HasPrefix(secret, AuthSecretPlaceholder)
+}
+
+//查询参数的规范形式，参数顺序不同的相同请求签名相同
+func CanonicalQuery(u *url.URL) string {
+	return u.Query().Encode()
+}
+
+func AuthSignature(secret []byte, method string, path string, query string, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + path + "\n" + query + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//生成签名使用的随机数
+func newAuthNonce() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		utils.Log.Error("newAuthNonce err:%s", err.Error())
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func readSignConfig() (caller string, secret string) {
+	return utils.ConfigFile.Read_string("auth", "caller", ""), utils.ConfigFile.Read_string("auth", "secret", "")
+}
+
+//启动时检查配置文件[auth]的caller和secret。没有配置或者密钥不能使用时，engine会拒绝此服务发送的所有请求，
+//在日志和标准输出中报错
+func CheckSignConfig() bool {
+
+	defer utils.DealPanic()
+	if caller, secret := readSignConfig(); caller == "" || !ValidAuthSecret(secret) {
+		msg := fmt.Sprintf("config.ini [auth] caller or secret is empty, shorter than %d or the %s placeholder, engine will reject every request sent by this service", MinAuthSecretLen, AuthSecretPlaceholder)
+		utils.Log.Error(msg)
+		fmt.Println(msg)
+		return false
+	}
+	return true
+}
+
+//按配置文件[auth]的caller和secret给请求签名，没有配置或者密钥不能使用时不签名
+func SignRequest(req *http.Request, body []byte) {
+
+	defer utils.DealPanic()
+	caller, secret := readSignConfig()
+	if caller == "" || !ValidAuthSecret(secret) {
+		return
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := newAuthNonce()
+	req.Header.Set(AuthCallerHeader, caller)
+	req.Header.Set(AuthTimestampHeader, timestamp)
+	req.Header.Set(AuthNonceHeader, nonce)
+	req.Header.Set(AuthSignatureHeader, AuthSignature([]byte(secret), req.Method, req.URL.Path, CanonicalQuery(req.URL), timestamp, nonce, body))
+}
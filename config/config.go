@@ -23,6 +23,16 @@ type sqlInfo struct {
 	Specialty         string
 	HeartbeatInterval string
 	RetryTime         string
+	H264capacity      string
+	H265capacity      string
+	Slowstart         string
+	Cpuhigh           string
+	Cpulow            string
+	Memhigh           string
+	Memlow            string
+	Iohigh            string
+	Iolow             string
+	Adminstate        string
 }
 
 type GlobConf struct {
@@ -35,9 +45,19 @@ type Backend struct {
 	Host            string
 	Healthcheckport int
 	Serverport      int
-	Specialty       string //此服务器特长点：CPU / GPU
+	Specialty       string //此服务器特长点：CPU / GPU / FPGA等，每种特长对应一种任务类型
 	Weight          int
 	Healthcheck     healthcheck
+	H264Capacity    int    //H264转码能力，可同时处理的任务数，0表示不处理H264任务
+	H265Capacity    int    //H265转码能力，可同时处理的任务数，0表示不处理H265任务
+	SlowStart       int    //从宕机恢复后的慢启动时长，单位秒，0表示使用engine配置的默认值
+	CpuHigh         int    //CPU使用率超过此值暂停分配，0表示使用engine配置的调度池阈值
+	CpuLow          int    //暂停分配后CPU使用率降到此值恢复
+	MemHigh         int    //内存使用率超过此值暂停分配，0表示使用engine配置的调度池阈值
+	MemLow          int    //暂停分配后内存使用率降到此值恢复
+	IoHigh          int    //IO等待超过此值暂停分配，0表示使用engine配置的调度池阈值
+	IoLow           int    //暂停分配后IO等待降到此值恢复
+	AdminState      string //管理状态：active 正常，drain 排空，maintenance 维护
 }
 
 type healthcheck struct {
@@ -91,6 +111,9 @@ func (s *CfgServer) LoadConfig() {
 	fmt.Println("s.Glob.SlbStateInterface = %v,s.Glob.Timeout=%v", s.Glob.SlbStateInterface, s.Glob.Timeout)
 	fmt.Println("connect SQL info :successfull")
 
+	//下发配置需要engine认证，密钥不能使用时启动报错
+	CheckSignConfig()
+
 	//打开数据库
 	if false == s.myDBOperator.InitSqlParam() {
 		utils.Log.Info("connect to db failed")
@@ -110,11 +133,13 @@ func (s *CfgServer) Run() {
 			time.Sleep(10 * time.Second)
 			continue
 		}
-		querySql := fmt.Sprintf("select name, healthcheckport,serverport, ip, weight, specialty, heartbeatInterval, retryTime from `slb_svr`;")
+		querySql := fmt.Sprintf("select name, healthcheckport,serverport, ip, weight, specialty, heartbeatInterval, retryTime, h264capacity, h265capacity, slowstart, cpuhigh, cpulow, memhigh, memlow, iohigh, iolow, adminstate from `slb_svr`;")
 		fmt.Println("ReadDb cmd:", querySql)
 		rows, err := s.myDBOperator.SlbDB.Query(querySql)
 		if err != nil {
 			fmt.Println("selectAllContent err:%v", err)
+			//旧数据库没有新增的字段时查询失败，需要执行slb_migrate.sql升级
+			utils.Log.Error("read slb_svr failed, run slb_migrate.sql if the db is not upgraded: %s", err.Error())
 			s.myDBOperator.closeDB()
 
 			time.Sleep(10 * time.Second)
@@ -204,7 +229,32 @@ func (s *CfgServer) ReadDbtoBackends(sqlInfoBackends []Backend, sqlInfoArray []s
 			continue
 		}
 		sqlInfoBackends[i].Healthcheck.RetryNum = temp
+
+		//转码能力、慢启动时长和过载阈值为可选项，没有填写时为0
+		sqlInfoBackends[i].H264Capacity = optionalAtoi(sqlInfoArray[i].H264capacity)
+		sqlInfoBackends[i].H265Capacity = optionalAtoi(sqlInfoArray[i].H265capacity)
+		sqlInfoBackends[i].SlowStart = optionalAtoi(sqlInfoArray[i].Slowstart)
+		sqlInfoBackends[i].CpuHigh = optionalAtoi(sqlInfoArray[i].Cpuhigh)
+		sqlInfoBackends[i].CpuLow = optionalAtoi(sqlInfoArray[i].Cpulow)
+		sqlInfoBackends[i].MemHigh = optionalAtoi(sqlInfoArray[i].Memhigh)
+		sqlInfoBackends[i].MemLow = optionalAtoi(sqlInfoArray[i].Memlow)
+		sqlInfoBackends[i].IoHigh = optionalAtoi(sqlInfoArray[i].Iohigh)
+		sqlInfoBackends[i].IoLow = optionalAtoi(sqlInfoArray[i].Iolow)
+		sqlInfoBackends[i].AdminState = sqlInfoArray[i].Adminstate
+	}
+}
+
+func optionalAtoi(str string) int {
+	if str == "" {
+		return 0
+	}
+
+	temp, err := strconv.Atoi(str)
+	if err != nil || temp < 0 {
+		utils.Log.Error("read optional field %s from db then strconv.Atoi failed err:%v", str, err)
+		return 0
 	}
+	return temp
 }
 
 func (s *CfgServer) checkIfShoudSend(sqlInfoArray []Backend) bool {
@@ -237,6 +287,8 @@ func (s *CfgServer) SendOut(url string) {
 	req, err := http.NewRequest("POST", postUrl, body)
 	req.Header.Set("X-Custom-Header", "myvalue")
 	req.Header.Set("Content-Type", "application/json")
+	//engine的配置接口需要认证
+	SignRequest(req, b)
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
@@ -12,3 +12,22 @@ func TestGetConfig(t *testing.T) {
 		fmt.Println("true")
 	}
 }
+
+func TestAuthSignature(t *testing.T) {
+
+	secret := []byte("0123456789abcdef")
+	sig := AuthSignature(secret, "POST", "/yfy/server/state", "", "1700000000", "n1", []byte("{}"))
+	if sig != AuthSignature(secret, "POST", "/yfy/server/state", "", "1700000000", "n1", []byte("{}")) {
+		t.Fatal("signature is not stable")
+	}
+	//随机数参与签名，换随机数重放需要重新签名
+	if sig == AuthSignature(secret, "POST", "/yfy/server/state", "", "1700000000", "n2", []byte("{}")) {
+		t.Error("nonce is not signed")
+	}
+
+	for secret, want := range map[string]bool{"": false, "short": false, "change-me-0123456789": false, "0123456789abcdef": true} {
+		if ValidAuthSecret(secret) != want {
+			t.Errorf("ValidAuthSecret(%q) != %v", secret, want)
+		}
+	}
+}
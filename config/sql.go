@@ -10,9 +10,14 @@ import (
 
 //读取json格式的 配置文件
 type PolicyInfo struct {
-	UserId   string
-	Priority string
-	Ip       string
+	UserId        string
+	Priority      string
+	Ip            string //固定服务器列表，以逗号分隔
+	Fallback      string
+	Rate          string
+	Burst         string
+	MaxConcurrent string
+	Weight        string
 }
 type DBOperator struct {
 	MysqlParam string
@@ -74,12 +79,13 @@ func (s *DBOperator) QueryPolicyTab() (error, int, string) {
 	s.connectDB()
 	defer s.closeDB()
 
-	updateSql := "select UserId , priority, Ip  from `slb_policy`"
+	updateSql := "select UserId , priority, Ip, Fallback, Rate, Burst, MaxConcurrent, Weight  from `slb_policy`"
 
 	rows, err := s.SlbDB.Query(updateSql)
 
 	if err != nil {
-		utils.Log.Info("queryPolicyTab:" + err.Error())
+		//旧数据库没有新增的字段时查询失败，需要执行slb_migrate.sql升级
+		utils.Log.Error("queryPolicyTab failed, run slb_migrate.sql if the db is not upgraded: %s", err.Error())
 		return err, 0, ""
 	}
 
@@ -89,7 +95,7 @@ func (s *DBOperator) QueryPolicyTab() (error, int, string) {
 
 }
 
-func (s *DBOperator) QueryAndInsertPolicyTab(userid string, priority int, ip string) (error, int) {
+func (s *DBOperator) QueryAndInsertPolicyTab(userid string, priority int, ip string, fallback string, rate float64, burst int, maxConcurrent int, weight int) (error, int) {
 
 	s.connectDB()
 	defer s.closeDB()
@@ -108,8 +114,8 @@ func (s *DBOperator) QueryAndInsertPolicyTab(userid string, priority int, ip str
 	utils.Log.Info("insertAndUpdateSeatState  rows = %d \n", len(ss))
 	if len(ss) == 0 {
 		//should insert
-		updateSql := "INSERT INTO `slb_policy` (UserId, priority, Ip) VALUES (?,?,?)"
-		ret, err := s.SlbDB.Exec(updateSql, userid, priority, ip)
+		updateSql := "INSERT INTO `slb_policy` (UserId, priority, Ip, Fallback, Rate, Burst, MaxConcurrent, Weight) VALUES (?,?,?,?,?,?,?,?)"
+		ret, err := s.SlbDB.Exec(updateSql, userid, priority, ip, fallback, rate, burst, maxConcurrent, weight)
 
 		if err != nil {
 			utils.Log.Info("file programTbl insert:" + err.Error())
@@ -124,8 +130,8 @@ func (s *DBOperator) QueryAndInsertPolicyTab(userid string, priority int, ip str
 		var ret sql.Result
 		var err error
 
-		updateSql := "UPDATE `slb_policy` SET priority = ? ,Ip = ?  WHERE UserId = ?"
-		ret, err = s.SlbDB.Exec(updateSql, priority, ip, userid)
+		updateSql := "UPDATE `slb_policy` SET priority = ? ,Ip = ?, Fallback = ?, Rate = ?, Burst = ?, MaxConcurrent = ?, Weight = ?  WHERE UserId = ?"
+		ret, err = s.SlbDB.Exec(updateSql, priority, ip, fallback, rate, burst, maxConcurrent, weight, userid)
 
 		if err != nil {
 			utils.Log.Info("file programTbl insert:" + err.Error())
@@ -136,3 +142,37 @@ func (s *DBOperator) QueryAndInsertPolicyTab(userid string, priority int, ip str
 		return err, 0
 	}
 }
+
+//删除用户策略
+func (s *DBOperator) DeletePolicyTab(userid string) (error, int) {
+
+	s.connectDB()
+	defer s.closeDB()
+
+	updateSql := "DELETE FROM `slb_policy` WHERE UserId = ?"
+	ret, err := s.SlbDB.Exec(updateSql, userid)
+	if err != nil {
+		utils.Log.Info("DeletePolicyTab failed:" + err.Error())
+		utils.Log.Info("err =%v", ret)
+		return err, 1
+	}
+	utils.Log.Info("DeletePolicyTab userid:%s", userid)
+	return nil, 0
+}
+
+//保存服务器管理状态
+func (s *DBOperator) UpdateServerAdminState(ip string, state string) (error, int) {
+
+	s.connectDB()
+	defer s.closeDB()
+
+	updateSql := "UPDATE `slb_svr` SET adminstate = ? WHERE ip = ?"
+	ret, err := s.SlbDB.Exec(updateSql, state, ip)
+	if err != nil {
+		utils.Log.Info("UpdateServerAdminState failed:" + err.Error())
+		utils.Log.Info("err =%v", ret)
+		return err, 1
+	}
+	utils.Log.Info("UpdateServerAdminState ip:%s state:%s", ip, state)
+	return nil, 0
+}
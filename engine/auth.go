@@ -0,0 +1,166 @@
+package engine
+
+//控制接口的认证：服务器状态、配置下发、用户策略和服务器管理接口只允许配置的调用方访问。调用方从配置文件[auth]读取：
+//	callers            调用方列表，以逗号分隔，为空时控制接口全部拒绝
+//	<调用方>_secret    调用方的密钥，至少16个字符，示例配置中的占位密钥不能使用
+//	<调用方>_scopes    允许访问的接口：state、config、policy、admin，以逗号分隔
+//	max_skew_seconds   HMAC签名允许的时间误差，默认60秒
+//请求头带X-Slb-Caller表明调用方，认证方式二选一：
+//	静态密钥  X-Slb-Key: <密钥>
+//	HMAC签名  X-Slb-Timestamp、X-Slb-Nonce和X-Slb-Signature，签名方法见config.AuthSignature，
+//	          时间误差内同一个调用方的随机数只能使用一次，截获的签名请求不能重放
+//选机接口面向普通客户端，不认证。租约接口也不认证：租约ID是选机时随机生成的128位值，
+//只在选机回复中返回给申请租约的客户端，持有租约ID就是续约和释放的凭证，和选机一样不需要调用方密钥
+import (
+	"bytes"
+	"common/utils"
+	"crypto/hmac"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"slb/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAuthMaxSkewSeconds int = 60
+
+//签名随机数的最大长度
+const maxAuthNonceLen int = 64
+
+//控制接口需要的权限
+var controlScopes = map[string]string{
+	severState:    "state",
+	configFile:    "config",
+	userPolicy:    "policy",
+	serverAdmin:   "admin",
+	serverList:    "admin",
+	selectExplain: "admin",
+}
+
+type authCaller struct {
+	secret []byte          //密钥
+	scopes map[string]bool //允许访问的接口
+}
+
+type authConfig struct {
+	callers map[string]*authCaller //调用方，为空时控制接口全部拒绝
+	maxSkew time.Duration          //HMAC签名允许的时间误差
+	nonces  *nonceCache            //已经使用过的签名随机数
+}
+
+//已经使用过的签名随机数，签名过期前同一个随机数不能再次使用
+type nonceCache struct {
+	lock sync.Mutex
+	seen map[string]time.Time //调用方和随机数到签名过期的时间
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+//记录一次使用，签名过期前已经使用过时返回false。同时清理已经过期的记录，记录数不超过时间误差内的控制请求数
+func (c *nonceCache) use(key string, expire time.Time, now time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.seen[key]; ok && !now.After(e) {
+		return false
+	}
+	for k, e := range c.seen {
+		if now.After(e) {
+			delete(c.seen, k)
+		}
+	}
+	c.seen[key] = expire
+	return true
+}
+
+func readAuthConfig() *authConfig {
+
+	defer utils.DealPanic()
+	a := &authConfig{callers: make(map[string]*authCaller), nonces: newNonceCache()}
+
+	skew, err := strconv.Atoi(utils.ConfigFile.Read_string("auth", "max_skew_seconds", strconv.Itoa(defaultAuthMaxSkewSeconds)))
+	if err != nil || skew <= 0 {
+		utils.Log.Error("auth max_skew_seconds is not right, use %d", defaultAuthMaxSkewSeconds)
+		skew = defaultAuthMaxSkewSeconds
+	}
+	a.maxSkew = time.Duration(skew) * time.Second
+
+	for _, name := range strings.Split(utils.ConfigFile.Read_string("auth", "callers", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		secret := utils.ConfigFile.Read_string("auth", name+"_secret", "")
+		if !config.ValidAuthSecret(secret) {
+			utils.Log.Error("auth caller:%s secret is empty, too short or a placeholder, ignore", name)
+			continue
+		}
+		c := &authCaller{secret: []byte(secret), scopes: make(map[string]bool)}
+		for _, scope := range strings.Split(utils.ConfigFile.Read_string("auth", name+"_scopes", ""), ",") {
+			if scope = strings.ToLower(strings.TrimSpace(scope)); scope != "" {
+				c.scopes[scope] = true
+			}
+		}
+		a.callers[name] = c
+	}
+
+	//没有调用方时收不到服务器状态和配置，启动时在日志和标准输出中报错
+	if len(a.callers) == 0 {
+		msg := "auth: no caller configured in config.ini [auth], control endpoints are closed, server state and config pushes will be rejected"
+		utils.Log.Error(msg)
+		fmt.Println(msg)
+	}
+	return a
+}
+
+//检查控制接口的请求，返回http状态码，通过时为200。HMAC签名需要读取body，读取后恢复r.Body
+func (a *authConfig) check(r *http.Request, scope string, now time.Time) int {
+
+	name := r.Header.Get(config.AuthCallerHeader)
+	c, ok := a.callers[name]
+	if !ok {
+		return http.StatusUnauthorized
+	}
+
+	if signature := r.Header.Get(config.AuthSignatureHeader); signature != "" {
+		timestamp := r.Header.Get(config.AuthTimestampHeader)
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return http.StatusUnauthorized
+		}
+		nonce := r.Header.Get(config.AuthNonceHeader)
+		if nonce == "" || len(nonce) > maxAuthNonceLen {
+			return http.StatusUnauthorized
+		}
+		if skew := now.Sub(time.Unix(ts, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+			utils.Log.Info("auth caller:%s signature expired, skew:%v", name, skew)
+			return http.StatusUnauthorized
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		expected := config.AuthSignature(c.secret, r.Method, r.URL.Path, config.CanonicalQuery(r.URL), timestamp, nonce, body)
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			return http.StatusUnauthorized
+		}
+		//签名正确后才记录随机数，签名过期前同一个随机数再次出现就是重放
+		if !a.nonces.use(name+"\n"+nonce, time.Unix(ts, 0).Add(a.maxSkew), now) {
+			utils.Log.Error("auth caller:%s nonce replayed", name)
+			return http.StatusUnauthorized
+		}
+	} else if !hmac.Equal([]byte(r.Header.Get(config.AuthKeyHeader)), c.secret) {
+		return http.StatusUnauthorized
+	}
+
+	if !c.scopes[scope] {
+		utils.Log.Info("auth caller:%s has no scope:%s", name, scope)
+		return http.StatusForbidden
+	}
+	return http.StatusOK
+}
@@ -2,15 +2,21 @@
 package engine
 
 import (
+	"bytes"
 	"common/utils"
+	"context"
+	"encoding/json"
 
 	"fmt"
+	"io/ioutil"
 	"net/http"
 
 	"slb/config"
 
 	"slb/strategy"
 
+	"strconv"
+	"strings"
 	"time"
 	//"github.com/gorilla/mux"
 	//"github.com/urfave/negroni"
@@ -24,14 +30,20 @@ var selectServer string = "/yfy/select/lb/server"
 var severState string = "/yfy/server/state"
 var userPolicy string = "/yfy/user/policy"
 var configFile string = "/yfy/server/configinfo"
+var selectLease string = "/yfy/select/lb/lease"
+var serverAdmin string = "/yfy/server/admin"
+var selectExplain string = "/yfy/select/lb/explain"
+var serverList string = "/yfy/server/list"
 
 type slb struct {
-	si strategy.StrategyInterface //调度策略接口
-	cf *config.Configuration      //全局配置文件
+	si           strategy.StrategyInterface //调度策略接口
+	cf           *config.Configuration      //全局配置文件
+	auth         *authConfig                //控制接口的认证
+	maxBodyBytes int                        //doWork模式可以缓存和重放的请求body最大字节数
 }
 
 func NewSlb() *slb {
-	return &slb{si: strategy.NewStrategy(), cf: nil}
+	return &slb{si: strategy.NewStrategy(), cf: nil, auth: readAuthConfig(), maxBodyBytes: strategy.ReadMaxBodyBytes()}
 }
 
 func (s *slb) Run() {
@@ -86,6 +98,15 @@ func (s *slb) dealRoute(w http.ResponseWriter, r *http.Request) {
 
 	defer utils.DealPanic()
 
+	//控制接口需要认证，选机接口保持开放
+	if scope, ok := controlScopes[r.URL.Path]; ok {
+		if status := s.auth.check(r, scope, time.Now()); status != http.StatusOK {
+			utils.Log.Info("auth failed, path:%s caller:%s status:%d", r.URL.Path, r.Header.Get(config.AuthCallerHeader), status)
+			w.WriteHeader(status)
+			return
+		}
+	}
+
 	if selectServer == r.URL.Path {
 
 		utils.Log.Debug("Match selectServer success,%s", r.URL.Path)
@@ -104,6 +125,18 @@ func (s *slb) dealRoute(w http.ResponseWriter, r *http.Request) {
 	} else if configFile == r.URL.Path {
 		utils.Log.Debug("Match dealUpdateConfig success,%s", r.URL.Path)
 		s.dealUpdateConfig(w, r)
+	} else if selectLease == r.URL.Path {
+		utils.Log.Debug("Match dealLease success,%s", r.URL.Path)
+		s.dealLease(w, r)
+	} else if serverAdmin == r.URL.Path {
+		utils.Log.Debug("Match dealServerAdmin success,%s", r.URL.Path)
+		s.dealServerAdmin(w, r)
+	} else if selectExplain == r.URL.Path {
+		utils.Log.Debug("Match dealExplain success,%s", r.URL.Path)
+		s.dealExplain(w, r)
+	} else if serverList == r.URL.Path {
+		utils.Log.Debug("Match dealServerList success,%s", r.URL.Path)
+		s.dealServerList(w, r)
 	} else {
 		utils.Log.Debug("Match other success,%s", r.URL.Path)
 		s.dealReqServer(w, r)
@@ -125,6 +158,14 @@ func (s *slb) dealReqServer(w http.ResponseWriter, r *http.Request) {
 	//	return
 	//}
 
+	//选机参数从完整的body解析，doWork模式代理时还要原样转发，连接失败重试时重放，所以缓存整个body；
+	//超过max_body_bytes时不能完整解析参数，回复413，不按默认参数处理
+	buf, ok := strategy.BufferBody(r, s.maxBodyBytes)
+	if !ok {
+		utils.Log.Error("slb req body exceeds max_body_bytes:%d, from:%s", s.maxBodyBytes, r.RemoteAddr)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
 	utils.ParseReqBodyToJsonUnclosed(r, body, true)
 	if "" == body.TaskType {
 		body.TaskType = "cpu"
@@ -132,32 +173,75 @@ func (s *slb) dealReqServer(w http.ResponseWriter, r *http.Request) {
 	if "" == body.ReqMode {
 		body.ReqMode = strategy.SelectServer
 	}
+	//selectServerJson模式或者Accept为application/json时，选机结果以JSON回复，不再302跳转
+	jsonReply := false
+	if body.ReqMode == strategy.SelectServerJson {
+		body.ReqMode = strategy.SelectServer
+		jsonReply = true
+	} else if body.ReqMode == strategy.SelectServer && strings.Contains(r.Header.Get("Accept"), "application/json") {
+		jsonReply = true
+	}
 
 	if body.ReqMode == strategy.SelectServer || body.ReqMode == strategy.DoWork {
+		//超时或者客户端断开后，排队中的任务会被丢弃
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+		defer cancel()
+		body.SetContext(ctx)
+
 		s.si.AddSlbReq(body)
-		select {
-		//给客户的回复
-		case res := <-*body.ResponseChan:
+		res := body.Wait()
+		if res == nil && jsonReply {
+			res = strategy.TimeoutResponse()
+			res.Describe()
+			writeJson(w, res.HttpStatus(), res)
+		} else if res == nil {
+			//回复超时
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
 			//302 answer
-			close(*body.ResponseChan)
-			if body.ReqMode == strategy.SelectServer {
-				//w.Write([]byte(res))
-
-				if "" == res {
-					w.WriteHeader(http.StatusInternalServerError)
-				} else {
-					url := "http://" + res + r.RequestURI
-					fmt.Println(url) //这里还是：http://yourdomain.com
-					http.Redirect(w, r, url, http.StatusFound)
+			if !res.Success() {
+				//选机失败，回复错误码，服务器忙或者转码槽位占满时为503，超过用户配额时为429
+				res.Describe()
+				if res.RetryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(res.RetryAfter))
 				}
+				writeJson(w, res.HttpStatus(), res)
+				return
+			}
+
+			//告诉客户端实际使用的调度池，降级时客户端可以调整编码参数
+			w.Header().Set("X-Slb-Pool", res.Pool)
+			if res.Fallback {
+				w.Header().Set("X-Slb-Fallback", "true")
+			}
+			if jsonReply {
+				res.Describe()
+				writeJson(w, http.StatusOK, res)
+			} else if body.ReqMode == strategy.SelectServer {
+				url := "http://" + res.IP + r.RequestURI
+				fmt.Println(url) //这里还是：http://yourdomain.com
+				if res.Lease != "" {
+					w.Header().Set("X-Slb-Lease", res.Lease)
+					w.Header().Set("X-Slb-Lease-Ttl", strconv.Itoa(res.TTL))
+				}
+				http.Redirect(w, r, url, http.StatusFound)
 
 				//do work for customer
 			} else if body.ReqMode == strategy.DoWork {
-				strategy.DoWorkForCustomer(w, r, res)
+				//连接服务器失败时换一台服务器重试
+				for {
+					r.Body = ioutil.NopCloser(bytes.NewReader(buf))
+					if !s.si.ProxyWork(w, r, body, res.IP) {
+						break
+					}
+
+					res = s.si.RetryWork(body)
+					if res == nil || !res.Success() {
+						w.WriteHeader(http.StatusBadGateway)
+						break
+					}
+				}
 			}
-		//回复超时
-		case <-time.After(time.Second * 10):
-			w.WriteHeader(http.StatusInternalServerError)
 		}
 	} else {
 		fmt.Println("not support this mode")
@@ -165,6 +249,14 @@ func (s *slb) dealReqServer(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//以JSON回复
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	buf, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
 func (s *slb) dealServerState(w http.ResponseWriter, r *http.Request) {
 
 	defer utils.DealPanic()
@@ -179,10 +271,17 @@ func (s *slb) dealServerState(w http.ResponseWriter, r *http.Request) {
 	s.si.UpdateServerState(body)
 }
 
+//用户策略：GET ?userid=xxx 查询一条，GET ?offset=0&limit=100 分页列出，
+//DELETE ?userid=xxx 删除，POST和PUT 添加或者修改
 func (s *slb) dealUserPolicy(w http.ResponseWriter, r *http.Request) {
 
 	defer utils.DealPanic()
 
+	if r.Method == http.MethodGet || r.Method == http.MethodDelete {
+		s.dealQueryPolicy(w, r)
+		return
+	}
+
 	body := strategy.NewUserPolicy()
 	if b := utils.ParseReqBodyToJson(r, body, true); !b {
 		w.WriteHeader(http.StatusBadRequest)
@@ -203,6 +302,146 @@ func (s *slb) dealUserPolicy(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//查询或者删除用户策略
+func (s *slb) dealQueryPolicy(w http.ResponseWriter, r *http.Request) {
+
+	defer utils.DealPanic()
+
+	query := r.URL.Query()
+	body := strategy.NewReqPolicyTask()
+	body.UserID = query.Get("userid")
+	if r.Method == http.MethodDelete {
+		body.Action = strategy.PolicyDelete
+	} else if body.UserID != "" {
+		body.Action = strategy.PolicyGet
+	} else {
+		body.Action = strategy.PolicyList
+		body.Offset, _ = strconv.Atoi(query.Get("offset"))
+		body.Limit, _ = strconv.Atoi(query.Get("limit"))
+	}
+	if body.Action != strategy.PolicyList && body.UserID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.si.ManageUserPolicy(body)
+
+	select {
+	//给客户的回复
+	case res := <-*body.ResponseChan:
+		close(*body.ResponseChan)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(res))
+	//回复超时
+	case <-time.After(time.Second * 5):
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//续约或者释放租约
+func (s *slb) dealLease(w http.ResponseWriter, r *http.Request) {
+
+	defer utils.DealPanic()
+
+	body := strategy.NewReqLeaseTask()
+	if b := utils.ParseReqBodyToJson(r, body, true); !b {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.si.UpdateLease(body)
+
+	select {
+	//给客户的回复
+	case res := <-*body.ResponseChan:
+		close(*body.ResponseChan)
+		w.Write([]byte(res))
+	//回复超时
+	case <-time.After(time.Second * 5):
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//查询或者设置服务器管理状态：GET ?ip=xxx 查询排空进度，POST {"Ip":"xxx","State":"drain"} 设置状态
+func (s *slb) dealServerAdmin(w http.ResponseWriter, r *http.Request) {
+
+	defer utils.DealPanic()
+
+	body := strategy.NewReqAdminTask()
+	if r.Method == http.MethodGet {
+		body.Ip = r.URL.Query().Get("ip")
+	} else if b := utils.ParseReqBodyToJson(r, body, true); !b {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.si.UpdateAdmin(body)
+
+	select {
+	//给客户的回复
+	case res := <-*body.ResponseChan:
+		close(*body.ResponseChan)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(res))
+	//回复超时
+	case <-time.After(time.Second * 5):
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//解释选机过程：body和选机请求相同，回复用户策略、优先级、每台候选服务器的情况和负载均衡算法的选择，
+//不占用服务器，用于排查用户为什么被分配到某台服务器
+func (s *slb) dealExplain(w http.ResponseWriter, r *http.Request) {
+
+	defer utils.DealPanic()
+
+	body := strategy.NewReqSlbTask()
+	utils.ParseReqBodyToJsonUnclosed(r, body, true)
+	if "" == body.TaskType {
+		body.TaskType = "cpu"
+	}
+	if "" == body.ReqMode || strategy.SelectServerJson == body.ReqMode {
+		body.ReqMode = strategy.SelectServer
+	}
+
+	req := strategy.NewReqExplainTask(body)
+	s.si.ExplainSlbReq(req)
+
+	select {
+	//给客户的回复
+	case res := <-*req.ResponseChan:
+		close(*req.ResponseChan)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(res))
+	//回复超时
+	case <-time.After(time.Second * 5):
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//只读查询服务器和调度池的快照：GET 查询全部，GET ?ip=xxx 只查询一台服务器
+func (s *slb) dealServerList(w http.ResponseWriter, r *http.Request) {
+
+	defer utils.DealPanic()
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := strategy.NewReqSnapshotTask()
+	body.Ip = r.URL.Query().Get("ip")
+	s.si.Snapshot(body)
+
+	select {
+	//给客户的回复
+	case res := <-*body.ResponseChan:
+		close(*body.ResponseChan)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(res))
+	//回复超时
+	case <-time.After(time.Second * 5):
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 func (s *slb) dealUpdateConfig(w http.ResponseWriter, r *http.Request) {
 
 	defer utils.DealPanic()
@@ -204,6 +204,8 @@ func (s *CheckTask) SetUp() {
 
 	config_ptr := goini.Init("config.ini")
 	s.listenPort = config_ptr.Read_string("WEB", "listen_port", "")
+	//上报服务器状态需要engine认证，密钥不能使用时启动报错
+	config.CheckSignConfig()
 }
 
 func NewCheckTask() *CheckTask {
@@ -265,6 +267,8 @@ func (s *singleHealthInfo) SendRespenseToEngine() {
 	req, err := http.NewRequest("POST", s.slbUrl, body)
 	//req.Header.Set("X-Custom-Header", "myvalue")
 	//req.Header.Set("Content-Type", "application/json")
+	//engine的服务器状态接口需要认证
+	config.SignRequest(req, jsoned_string)
 	client := &http.Client{}
 	resp, err := client.Do(req)
 
@@ -0,0 +1,156 @@
+package strategy
+
+//服务器管理状态：排空(drain)和维护(maintenance)的服务器不再分配新的请求，
+//已经分配的租约和doWork代理继续运行直到结束，正在处理的任务数为0、没有申请租约的selectServer槽位也都到期时排空完成。
+//管理状态保存在slb_svr表的adminstate字段，配置更新时随服务器配置一起下发，
+//因此设置时先写数据库，成功后再修改内存，否则下次配置下发会把状态改回去
+import (
+	"common/utils"
+	"strings"
+	"time"
+)
+
+type adminState string
+
+//服务器管理状态的持久化，默认为数据库slb_svr表
+type adminStore interface {
+	UpdateServerAdminState(ip string, state string) (error, int)
+}
+
+const (
+	AdminActive      = adminState("active")      //正常分配
+	AdminDrain       = adminState("drain")       //排空：不再分配新请求，等待已有任务结束
+	AdminMaintenance = adminState("maintenance") //维护：不再分配新请求，服务器下线维护
+)
+
+//查询或者设置服务器管理状态时，客户端带的body参数
+type ReqAdminTask struct {
+	Ip           string       //服务器IP
+	State        adminState   //要设置的状态，为空时只查询
+	ResponseChan *chan string //针对每个请求的同步回复通道
+}
+
+//针对ReqAdminTask的回复
+type responseAdminReq struct {
+	RetCode       retCode    //成功此值是0，失败非0
+	Result        string     //结果描述
+	Ip            string     //服务器IP
+	State         adminState //当前管理状态
+	Inflight      int        //正在处理的任务数，包括doWork代理和未释放的租约
+	Leases        int        //未释放的租约数
+	Slots         int        //没有申请租约的selectServer请求占用的未到期槽位数
+	DrainComplete bool       //不再分配新请求，并且已有任务都已结束
+}
+
+func NewReqAdminTask() *ReqAdminTask {
+	r := &ReqAdminTask{}
+	chanStr := make(chan string, 1)
+	r.ResponseChan = &chanStr
+	return r
+}
+
+//统一管理状态的写法，空值为正常分配，不认识的状态返回false
+func parseAdminState(str string) (adminState, bool) {
+	st := adminState(strings.ToLower(strings.TrimSpace(str)))
+	switch st {
+	case "":
+		return AdminActive, true
+	case AdminActive, AdminDrain, AdminMaintenance:
+		return st, true
+	}
+	return st, false
+}
+
+//是否接受新的请求
+func (s *server) accepting() bool {
+	return s.adminState == "" || s.adminState == AdminActive
+}
+
+//设置服务器管理状态，状态变化时重建调度池。调用者需要持有s.lock
+func (s *strategy) setAdminState(ser *server, state adminState) {
+	if ser.adminState == state {
+		return
+	}
+
+	utils.Log.Info("server:%s admin state %s -> %s, inflight:%d", ser.ip, string(ser.adminState), string(state), ser.inflight)
+	ser.adminState = state
+	ser.drainReported = false
+	s.rebuildPool(poolOf(ser))
+}
+
+//服务器上未释放的租约数，调用者需要持有s.lock
+func (s *strategy) leaseCount(ser *server) int {
+	n := 0
+	for _, l := range s.leases {
+		if l.server == ser {
+			n++
+		}
+	}
+	return n
+}
+
+//排空是否完成：不再分配新请求，没有正在处理的任务，selectServer的槽位也都已到期或者释放。
+//selectServer模式下slb不知道任务何时结束，槽位未到期时任务可能还在服务器上运行
+func (s *server) drained(now time.Time) bool {
+	return !s.accepting() && s.inflight == 0 && s.heldSlots(now) == 0
+}
+
+//排空的服务器任务全部结束时记录日志，调用者需要持有s.lock
+func (s *strategy) checkDrained(now time.Time) {
+	for _, v := range s.servers {
+		if v.drainReported || !v.drained(now) {
+			continue
+		}
+		v.drainReported = true
+		utils.Log.Info("server:%s %s complete, no task running", v.ip, string(v.adminState))
+	}
+}
+
+//处理查询和设置服务器管理状态的请求，设置的状态先保存到数据库。只在Run协程中调用
+func (s *strategy) dealAdmin(req *ReqAdminTask) {
+	defer utils.DealPanic()
+
+	response := &responseAdminReq{Ip: req.Ip}
+	state, ok := parseAdminState(string(req.State))
+
+	//服务器集合只在Run协程中修改，这里读取不需要加锁
+	ser, exist := s.servers[serverIP(req.Ip)]
+	if !exist {
+		response.RetCode = retCodeFail
+		response.Result = "fail,the ip is not in the servers list"
+	} else if !ok {
+		response.RetCode = retCodeFail
+		response.Result = "fail,the state should be active, drain or maintenance"
+	} else if req.State != "" && !s.saveAdminState(req.Ip, state) {
+		response.RetCode = retCodeFail
+		response.Result = "fail,save to db failed"
+	} else {
+		s.lock.Lock()
+		if req.State != "" {
+			s.setAdminState(ser, state)
+		}
+		response.RetCode = retCodeSuccess
+		response.Result = "success"
+		response.State = ser.adminState
+		if response.State == "" {
+			response.State = AdminActive
+		}
+		response.Inflight = ser.inflight
+		response.Leases = s.leaseCount(ser)
+		response.Slots = ser.heldSlots(time.Now())
+		response.DrainComplete = ser.drained(time.Now())
+		s.lock.Unlock()
+	}
+
+	utils.Log.Debug("deal admin server:%s state:%s result:%s", req.Ip, string(req.State), response.Result)
+	responseToClient(req.ResponseChan, response)
+}
+
+//保存管理状态到数据库，失败时不修改内存中的状态
+func (s *strategy) saveAdminState(ip string, state adminState) bool {
+	if err, _ := s.adminStore.UpdateServerAdminState(ip, string(state)); err != nil {
+		utils.Log.Error("save server:%s admin state:%s to db failed: %s", ip, string(state), err.Error())
+		return false
+	}
+	return true
+}
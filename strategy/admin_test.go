@@ -0,0 +1,133 @@
+package strategy
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func queryAdmin(t *testing.T, s *strategy, ip string) *responseAdminReq {
+	req := NewReqAdminTask()
+	req.Ip = ip
+	return queryAdminTask(t, s, req)
+}
+
+func queryAdminTask(t *testing.T, s *strategy, req *ReqAdminTask) *responseAdminReq {
+	s.dealAdmin(req)
+	res := &responseAdminReq{}
+	if err := json.Unmarshal([]byte(<-*req.ResponseChan), res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestDrainServer(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU")
+	s.leases = make(map[string]*lease)
+	s.updateBalancers()
+	servers := s.poolServers(CpuPro)
+	drained := servers[0]
+
+	r := &ReqSlbTask{TaskType: string(CpuPro), ReqMode: DoWork}
+	s.occupy(r, drained, &responseSlbReq{})
+	s.setAdminState(drained, AdminDrain)
+
+	//排空的服务器不再分配新请求
+	for i := 0; i < 10; i++ {
+		ser, _, err := s.getBackendServer(&ReqSlbTask{TaskType: string(CpuPro)})
+		if err != nil || ser == drained {
+			t.Fatalf("picked drained server or failed: %v", err)
+		}
+	}
+
+	res := queryAdmin(t, s, drained.ip)
+	if res.State != AdminDrain || res.Inflight != 1 || res.DrainComplete {
+		t.Fatalf("drain in progress reported as %+v", res)
+	}
+
+	//已有的代理结束后排空完成
+	s.FinishWork(r, true)
+	res = queryAdmin(t, s, drained.ip)
+	if res.Inflight != 0 || !res.DrainComplete {
+		t.Fatalf("drain finished reported as %+v", res)
+	}
+
+	if res = queryAdmin(t, s, servers[1].ip); res.State != AdminActive || res.DrainComplete {
+		t.Errorf("active server reported as %+v", res)
+	}
+}
+
+func TestDrainWaitsForHeldSlots(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU")
+	s.leases = make(map[string]*lease)
+	s.slotHold = time.Minute
+	s.updateBalancers()
+	drained := s.servers["192.168.1.1"]
+	drained.H264Capacity = 2
+
+	//没有申请租约的selectServer请求占用的槽位，到期前任务可能还在运行
+	r := &ReqSlbTask{TaskType: string(CpuPro), Codec: H264}
+	s.occupy(r, drained, &responseSlbReq{})
+	s.setAdminState(drained, AdminDrain)
+
+	res := queryAdmin(t, s, drained.ip)
+	if res.Inflight != 0 || res.Slots != 1 || res.DrainComplete {
+		t.Fatalf("drain with held slot reported as %+v", res)
+	}
+	s.checkDrained(time.Now())
+	if drained.drainReported {
+		t.Fatal("drain reported complete with a held slot")
+	}
+
+	//槽位到期回收后排空完成
+	s.reclaimSlots(time.Now().Add(2 * time.Minute))
+	if !drained.drainReported {
+		t.Error("drain not reported after the slot expired")
+	}
+	if res = queryAdmin(t, s, drained.ip); res.Slots != 0 || !res.DrainComplete {
+		t.Errorf("drain after slot expired reported as %+v", res)
+	}
+}
+
+//保存在内存中的管理状态，fail为true时模拟数据库失败
+type memAdminStore struct {
+	states map[string]string
+	fail   bool
+}
+
+func (m *memAdminStore) UpdateServerAdminState(ip string, state string) (error, int) {
+	if m.fail {
+		return errors.New("db down"), 1
+	}
+	m.states[ip] = state
+	return nil, 0
+}
+
+func TestAdminStateSavedBeforeApplied(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU")
+	s.leases = make(map[string]*lease)
+	s.updateBalancers()
+	store := &memAdminStore{states: make(map[string]string)}
+	s.adminStore = store
+
+	req := NewReqAdminTask()
+	req.Ip, req.State = "192.168.1.1", AdminDrain
+	if res := queryAdminTask(t, s, req); res.RetCode != retCodeSuccess || store.states["192.168.1.1"] != "drain" {
+		t.Fatalf("drain %+v, db %v", res, store.states)
+	}
+
+	//数据库失败时回复失败，内存中的状态不变，下次配置下发时不会被改回去
+	store.fail = true
+	req = NewReqAdminTask()
+	req.Ip, req.State = "192.168.1.2", AdminMaintenance
+	if res := queryAdminTask(t, s, req); res.RetCode != retCodeFail {
+		t.Fatalf("maintenance with db down %+v", res)
+	}
+	if !s.servers["192.168.1.2"].accepting() {
+		t.Error("admin state changed although db failed")
+	}
+}
@@ -0,0 +1,54 @@
+package strategy
+
+import (
+	"common/utils"
+	"sort"
+	"strings"
+)
+
+//负载均衡算法接口，每种任务类型的服务器池对应一个实现。
+//所有方法都在持有strategy.lock的情况下调用，实现内部不需要再加锁
+type Balancer interface {
+
+	//为请求选出一台服务器
+	Pick(r *ReqSlbTask) (*server, error)
+
+	//服务器池成员或者服务器可用状态发生变化时，重建内部数据
+	Rebuild(servers []*server)
+
+	//预测下一次Pick选出的服务器，不改变内部状态，用于解释选机结果
+	Peek(r *ReqSlbTask) (*server, error)
+}
+
+//根据任务类型创建负载均衡算法，任务类型可用于读取该类型的专属配置
+type balancerCreator func(tp taskProperty) Balancer
+
+//已注册的负载均衡算法，新算法在自己文件的init中调用registerBalancer即可
+var balancerCreators = make(map[selectServerMode]balancerCreator)
+
+func registerBalancer(mode selectServerMode, creator balancerCreator) {
+	balancerCreators[mode] = creator
+}
+
+//从配置文件[select_mode]读取某种任务类型使用的算法，未配置或者不支持时使用轮询
+func readSelectMode(tp taskProperty) selectServerMode {
+
+	defer utils.DealPanic()
+	mode := selectServerMode(strings.ToLower(utils.ConfigFile.Read_string("select_mode", string(tp), string(RoundRobin))))
+	if _, ok := balancerCreators[mode]; !ok {
+		utils.Log.Error("task type:%s not support select mode:%s, use roundrobin", string(tp), string(mode))
+		mode = RoundRobin
+	}
+
+	utils.Log.Debug("task type:%s select mode:%s", string(tp), string(mode))
+	return mode
+}
+
+func newBalancer(tp taskProperty) Balancer {
+	return balancerCreators[readSelectMode(tp)](tp)
+}
+
+//按IP排序，保证每次重建时服务器顺序一致
+func sortServers(servers []*server) {
+	sort.Slice(servers, func(i, j int) bool { return servers[i].ip < servers[j].ip })
+}
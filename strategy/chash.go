@@ -0,0 +1,111 @@
+package strategy
+
+import (
+	"common/utils"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+//每一点权重对应的虚拟节点个数，权重最大为100，单台服务器最多1000个虚拟节点
+const chashVirtualNodes int = 10
+
+//一致性哈希，按用户ID或会话ID把请求固定到同一台服务器上，
+//服务器加入或退出时，只有约1/N的用户会被重新分配
+type cHash struct {
+	servers []*server          //服务器列表
+	ring    []uint32           //排好序的虚拟节点哈希值
+	nodes   map[uint32]*server //虚拟节点到服务器的映射
+	next    int                //没有用户ID和会话ID的请求使用的计数器
+}
+
+func init() {
+	registerBalancer(CHash, func(tp taskProperty) Balancer { return NewCHash(nil) })
+}
+
+func NewCHash(inputServers []*server) *cHash {
+
+	defer utils.DealPanic()
+	c := &cHash{}
+	c.Rebuild(inputServers)
+	return c
+}
+
+//重建哈希环，只有状态正常的服务器才会放到环上
+func (c *cHash) Rebuild(servers []*server) {
+
+	defer utils.DealPanic()
+	c.servers = servers
+	c.ring = nil
+	c.nodes = make(map[uint32]*server)
+
+	for _, s := range c.servers {
+		if !checkSeverState(s) {
+			continue
+		}
+
+		nodeKey := s.ip + ":" + strconv.Itoa(s.port)
+		for i := 0; i < s.weight*chashVirtualNodes; i++ {
+			h := crc32.ChecksumIEEE([]byte(nodeKey + "#" + strconv.Itoa(i)))
+			if old, ok := c.nodes[h]; ok {
+				//哈希冲突时保留IP较小的服务器，保证结果与遍历顺序无关
+				if old.ip <= s.ip {
+					continue
+				}
+			} else {
+				c.ring = append(c.ring, h)
+			}
+			c.nodes[h] = s
+		}
+	}
+
+	sort.Slice(c.ring, func(i, j int) bool { return c.ring[i] < c.ring[j] })
+	utils.Log.Debug("chash rebuild, servers:%d, virtual nodes:%d", len(c.servers), len(c.ring))
+}
+
+//根据key在哈希环上顺时针查找第一个可以分配的虚拟节点，
+//服务器没有空闲转码槽位时顺延到下一台，不影响其他用户的分配
+func (c *cHash) getBackendServer(key string, r *ReqSlbTask) (*server, error) {
+
+	defer utils.DealPanic()
+	if len(c.ring) == 0 {
+		utils.Log.Debug(" no server")
+		return nil, errors.New("no server")
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(c.ring), func(i int) bool { return c.ring[i] >= h })
+	for i := 0; i < len(c.ring); i++ {
+		s := c.nodes[c.ring[(idx+i)%len(c.ring)]]
+		if serverAvailable(s, r) {
+			return s, nil
+		}
+	}
+
+	return nil, errors.New("no server")
+}
+
+func (c *cHash) Pick(r *ReqSlbTask) (*server, error) {
+
+	key := hashKey(r)
+	//没有用户ID和会话ID的请求无法固定服务器，用计数器生成key，按虚拟节点数分散
+	if key == "" {
+		c.next++
+		key = strconv.Itoa(c.next)
+	}
+	return c.getBackendServer(key, r)
+}
+
+func (c *cHash) Peek(r *ReqSlbTask) (*server, error) {
+	peek := *c
+	return peek.Pick(r)
+}
+
+//一致性哈希使用的key，优先使用用户ID，没有用户ID时使用会话ID
+func hashKey(r *ReqSlbTask) string {
+	if r.UserID != "" {
+		return r.UserID
+	}
+	return r.SessonID
+}
@@ -0,0 +1,107 @@
+package strategy
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+//每个用户分配到的服务器
+func chashAssign(t *testing.T, c *cHash, users int) map[string]*server {
+	assign := make(map[string]*server)
+	for i := 0; i < users; i++ {
+		id := "user" + strconv.Itoa(i)
+		s, err := c.Pick(&ReqSlbTask{UserID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assign[id] = s
+	}
+	return assign
+}
+
+func TestCHashStable(t *testing.T) {
+
+	c := NewCHash(newTestServers(10, 10, 10, 10))
+	first := chashAssign(t, c, 1000)
+	for id, s := range chashAssign(t, c, 1000) {
+		if first[id] != s {
+			t.Fatalf("user %s moved from %s to %s", id, first[id].ip, s.ip)
+		}
+	}
+
+	//重建后顺序不同结果也相同
+	servers := newTestServers(10, 10, 10, 10)
+	c.Rebuild([]*server{servers[3], servers[1], servers[0], servers[2]})
+	for id, s := range chashAssign(t, c, 1000) {
+		if first[id].ip != s.ip {
+			t.Fatalf("user %s moved from %s to %s after rebuild", id, first[id].ip, s.ip)
+		}
+	}
+}
+
+func TestCHashRemapFraction(t *testing.T) {
+
+	const users = 10000
+	servers := newTestServers(10, 10, 10, 10, 10, 10)
+	c := NewCHash(servers[:5])
+	before := chashAssign(t, c, users)
+
+	//加入第6台，只有约1/6的用户移动，并且都移动到新服务器上
+	c.Rebuild(servers)
+	joined := chashAssign(t, c, users)
+	moved := 0
+	for id, s := range joined {
+		if s != before[id] {
+			moved++
+			if s != servers[5] {
+				t.Fatalf("user %s moved from %s to old server %s", id, before[id].ip, s.ip)
+			}
+		}
+	}
+	if frac := float64(moved) / users; frac < 0.5/6 || frac > 1.5/6 {
+		t.Errorf("join moved %.3f of users, want about %.3f", frac, 1.0/6)
+	}
+
+	//第1台宕机，只有它上面的用户移动，约1/6
+	servers[0].state.Down = true
+	c.Rebuild(servers)
+	moved = 0
+	for id, s := range chashAssign(t, c, users) {
+		if s == joined[id] {
+			continue
+		}
+		moved++
+		if joined[id] != servers[0] {
+			t.Fatalf("user %s moved from healthy server %s", id, joined[id].ip)
+		}
+	}
+	if frac := float64(moved) / users; frac < 0.5/6 || frac > 1.5/6 {
+		t.Errorf("leave moved %.3f of users, want about %.3f", frac, 1.0/6)
+	}
+}
+
+func TestCHashSkipsFullServer(t *testing.T) {
+
+	servers := newTestServers(10, 10, 10)
+	for _, s := range servers {
+		s.H264Capacity = 1
+	}
+	c := NewCHash(servers)
+
+	r := &ReqSlbTask{UserID: "u1", Codec: H264}
+	first, err := c.Pick(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//服务器没有空闲槽位时顺延到下一台，槽位释放后回到原来的服务器
+	slot := first.acquireSlot(r, time.Time{})
+	next, err := c.Pick(r)
+	if err != nil || next == first {
+		t.Fatalf("full server %s picked again: %v", first.ip, err)
+	}
+	slot.release()
+	if again, _ := c.Peek(r); again != first {
+		t.Errorf("user did not return to %s after slot release", first.ip)
+	}
+}
@@ -3,13 +3,42 @@ package strategy
 
 import (
 	"common/utils"
+	"errors"
+	"net"
 
 	"net/http"
 	"net/http/httputil"
 )
 
+//幂等的请求方法，连接建立后失败也可以重试
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodTrace:   true,
+}
+
+//代理失败时能否换一台服务器重试：连接不上服务器时请求还没有发出，都可以重试；
+//连接建立后失败(例如连接被重置)时，只重试幂等的请求
+func retryableError(req *http.Request, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return idempotentMethods[req.Method]
+}
+
 // should be modified
-func DoWorkForCustomer(w http.ResponseWriter, req *http.Request, strIP string) {
+//返回值ok表示代理是否成功，连接后端服务器失败或者后端返回5xx时为false。
+//canRetry为true表示请求body已经缓存，可以重放，此时在给客户端写任何数据之前失败，
+//不回复502，返回retry为true，由调用者换一台服务器重试
+func DoWorkForCustomer(w http.ResponseWriter, req *http.Request, strIP string, canRetry bool) (ok bool, retry bool) {
 
 	//跨域
 
@@ -21,12 +50,52 @@ func DoWorkForCustomer(w http.ResponseWriter, req *http.Request, strIP string) {
 
 	utils.Log.Debug("DoWorkForCustomer 111 original received then send to req.URL.Host:%s", req.URL.Host)
 	director := func(req *http.Request) {
-		req = req
 		req.URL.Scheme = "http"
 		req.URL.Host = strIP
 	}
+	ok = true
+	errorHandler := func(w http.ResponseWriter, req *http.Request, err error) {
+		utils.Log.Error("proxy to server:%s err:%s", strIP, err.Error())
+		ok = false
+		if canRetry && retryableError(req, err) {
+			retry = true
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	modifyResponse := func(res *http.Response) error {
+		if res.StatusCode >= http.StatusInternalServerError {
+			utils.Log.Error("proxy to server:%s response status:%d", strIP, res.StatusCode)
+			ok = false
+		}
+		return nil
+	}
 	utils.Log.Debug("received then send to server:%s", strIP)
-	proxy := &httputil.ReverseProxy{Director: director}
+	proxy := &httputil.ReverseProxy{Director: director, ErrorHandler: errorHandler, ModifyResponse: modifyResponse}
 	proxy.ServeHTTP(w, req)
 
+	return ok, retry
+
+}
+
+//代理一次doWork请求，不需要换服务器重试时结束本次代理，释放占用的资源，返回是否需要重试。
+//复制body中途失败时(例如客户端断开)ReverseProxy会panic(http.ErrAbortHandler)，
+//panic时也结束本次代理，否则服务器的inflight、转码槽位和用户并发配额永远不会释放
+func (s *strategy) ProxyWork(w http.ResponseWriter, req *http.Request, r *ReqSlbTask, strIP string) bool {
+
+	finished := false
+	defer func() {
+		if !finished {
+			//客户端断开不是服务器的问题，不计为失败
+			s.FinishWork(r, req.Context().Err() != nil)
+		}
+	}()
+
+	//engine已经缓存了body，每次代理前重置，可以重放
+	ok, retry := DoWorkForCustomer(w, req, strIP, true)
+	finished = true
+	if !retry {
+		s.FinishWork(r, ok)
+	}
+	return retry
 }
@@ -0,0 +1,154 @@
+package strategy
+
+//选机解释：按选机相同的流程走一遍并记录每一步的决定，用于排查用户为什么被分配到某台服务器。
+//解释不占用服务器和转码槽位、不消耗用户配额，也不改变负载均衡算法和降级链的状态
+import (
+	"common/utils"
+	"fmt"
+	"time"
+)
+
+//解释选机请求，Req和选机请求的body相同
+type ReqExplainTask struct {
+	Req          *ReqSlbTask  //要解释的选机请求
+	ResponseChan *chan string //针对每个请求的同步回复通道
+}
+
+//一台候选服务器的情况
+type explainServer struct {
+	Ip        string //服务器IP
+	Pool      string //所在的调度池
+	Available bool   //是否可以分配
+	Reason    string //不可以分配的原因
+	Weight    int    //本轮权重，慢启动期间为慢启动权重
+	Inflight  int    //正在处理的任务数
+}
+
+//针对ReqExplainTask的回复
+type responseExplainReq struct {
+	RetCode        retCode         //按当前状态选机的结果
+	Reason         string          //结果描述
+	UserID         string          //用户ID
+	PolicyMatched  bool            //是否有此用户的策略
+	Priority       int             //进入优先级队列时的优先级
+	Pinned         []string        //用户策略指定的服务器
+	PinnedFallback string          //指定的服务器都不可用时的处理
+	TaskType       string          //任务类型
+	Servers        []explainServer //任务类型调度池中的服务器
+	Choice         string          //选中的服务器IP:端口
+	Pool           string          //选中服务器所在的调度池
+	Fallback       bool            //是否降级到了其他调度池
+	Trace          []string        //决定过程
+}
+
+func NewReqExplainTask(r *ReqSlbTask) *ReqExplainTask {
+	req := &ReqExplainTask{Req: r}
+	chanStr := make(chan string, 1)
+	req.ResponseChan = &chanStr
+	return req
+}
+
+func (e *responseExplainReq) trace(format string, a ...interface{}) {
+	e.Trace = append(e.Trace, fmt.Sprintf(format, a...))
+}
+
+//结束解释，按选机的回复填写结果
+func (e *responseExplainReq) finish(res *responseSlbReq) {
+	e.RetCode = res.RetCode
+	e.Reason = retCodeReasons[res.RetCode]
+	e.Choice = res.IP
+	e.Pool = res.Pool
+	e.Fallback = res.Fallback
+}
+
+//是否是解释选机的预测
+func (r *ReqSlbTask) dryRun() bool {
+	return r.explain != nil
+}
+
+//解释选机时记录一步决定，正常选机时不记录
+func (r *ReqSlbTask) trace(format string, a ...interface{}) {
+	if r.explain != nil {
+		r.explain.trace(format, a...)
+	}
+}
+
+//用负载均衡算法选出一台服务器，解释选机时只预测，不改变算法的状态
+func pickServer(b Balancer, r *ReqSlbTask) (*server, error) {
+	if r.dryRun() {
+		return b.Peek(r)
+	}
+	return b.Pick(r)
+}
+
+//服务器不能分配的原因，可以分配时返回空。顺序和checkSeverState、serverAvailable一致
+func unavailableReason(s *server, r *ReqSlbTask, now time.Time) string {
+	switch {
+	case s.state.Down:
+		return "down"
+	case s.overloaded:
+		return fmt.Sprintf("overloaded, cpu:%d mem:%d io:%d", s.state.CupUtil, s.state.MemUtil, s.state.IoWait)
+	case s.ejected(now):
+		return "ejected by outlier detection until " + s.ejectUntil.Format("15:04:05")
+	case !s.accepting():
+		return "admin state " + string(s.adminState)
+	case !s.hasFreeSlot(r.Codec):
+		return "no free " + string(r.Codec) + " slot"
+	}
+	return ""
+}
+
+//任务类型调度池中的候选服务器，调用者需要持有s.lock
+func (s *strategy) explainServers(r *ReqSlbTask, now time.Time) []explainServer {
+	var servers []explainServer
+	for _, v := range s.poolServers(taskProperty(r.TaskType)) {
+		reason := unavailableReason(v, r, now)
+		servers = append(servers, explainServer{
+			Ip:        v.ip,
+			Pool:      string(poolOf(v)),
+			Available: reason == "",
+			Reason:    reason,
+			Weight:    v.rampedWeight(now),
+			Inflight:  v.inflight,
+		})
+	}
+	return servers
+}
+
+//按dealSlbReq相同的流程预测选机：不进入队列，直接按队列协程的流程选机。只在Run协程中调用
+func (s *strategy) dealExplain(req *ReqExplainTask) {
+	defer utils.DealPanic()
+
+	r := req.Req
+	e := &responseExplainReq{UserID: r.UserID, TaskType: r.TaskType}
+	defer responseToClient(req.ResponseChan, e)
+
+	if up, ok := s.usersPolicy[userID(r.UserID)]; ok {
+		e.PolicyMatched = true
+		if len(up.Ips) > 0 {
+			e.PinnedFallback = string(up.Fallback)
+		}
+		for _, ip := range up.Ips {
+			e.Pinned = append(e.Pinned, string(ip))
+		}
+	}
+
+	s.lock.Lock()
+	e.Servers = s.explainServers(r, time.Now())
+	s.lock.Unlock()
+
+	chanRes := make(chan *responseSlbReq, 1)
+	r.ResponseChan = &chanRes
+	r.explain = e
+	s.dealSlbReq(r)
+	r.explain = nil
+	e.Priority = int(r.priority)
+
+	select {
+	case res := <-chanRes:
+		e.finish(res)
+	default:
+		//engine已经放弃等待，选机流程没有回复
+		e.finish(&responseSlbReq{RetCode: retCodeTimeout})
+	}
+}
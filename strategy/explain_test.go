@@ -0,0 +1,115 @@
+package strategy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func explain(t *testing.T, s *strategy, r *ReqSlbTask) *responseExplainReq {
+	req := NewReqExplainTask(r)
+	s.dealExplain(req)
+	res := &responseExplainReq{}
+	if err := json.Unmarshal([]byte(<-*req.ResponseChan), res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestExplainDoesNotConsumePick(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU", "CPU")
+	s.usersPolicy = map[userID]*UserPolicy{"u1": {UserID: "u1", Priority: 7}}
+	s.quotas = make(map[userID]*userQuota)
+	s.updateBalancers()
+	s.servers["192.168.1.1"].state.Down = true
+	s.servers["192.168.1.2"].adminState = AdminDrain
+
+	r := &ReqSlbTask{UserID: "u1", TaskType: string(CpuPro), ReqMode: DoWork}
+	first := explain(t, s, r)
+	second := explain(t, s, r)
+	if first.RetCode != retCodeSuccess || !first.PolicyMatched || first.Priority != 7 {
+		t.Fatalf("explain reply %+v", first)
+	}
+	if first.Choice != "192.168.1.3:80" || second.Choice != first.Choice {
+		t.Fatalf("explain choice %s then %s", first.Choice, second.Choice)
+	}
+
+	reasons := map[string]string{}
+	for _, v := range first.Servers {
+		reasons[v.Ip] = v.Reason
+	}
+	if reasons["192.168.1.1"] != "down" || reasons["192.168.1.2"] != "admin state drain" || reasons["192.168.1.3"] != "" {
+		t.Errorf("candidate reasons %v", reasons)
+	}
+	if s.servers["192.168.1.3"].inflight != 0 {
+		t.Error("explain occupied the server")
+	}
+}
+
+func TestExplainMatchesNextPick(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU", "CPU")
+	s.usersPolicy = make(map[userID]*UserPolicy)
+	s.updateBalancers()
+
+	//解释不推进轮询，之后的选机结果和解释一致
+	for i := 0; i < 6; i++ {
+		r := &ReqSlbTask{TaskType: string(CpuPro)}
+		res := explain(t, s, r)
+		ser, _, err := s.getBackendServer(r)
+		if err != nil || res.Choice != ser.ip+":80" {
+			t.Fatalf("pick %d explained %s, picked %v", i, res.Choice, ser)
+		}
+	}
+}
+
+func TestExplainPinnedAndUnknownType(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU")
+	up := &UserPolicy{UserID: "u1", Ips: []serverIP{"192.168.1.2"}}
+	up.normalizePinned()
+	s.usersPolicy = map[userID]*UserPolicy{"u1": up}
+	s.updateBalancers()
+	s.servers["192.168.1.2"].state.Down = true
+
+	res := explain(t, s, &ReqSlbTask{UserID: "u1", TaskType: string(CpuPro)})
+	if res.RetCode != retCodeFail || len(res.Pinned) != 1 || res.PinnedFallback != string(PinnedFallbackFail) {
+		t.Errorf("pinned explain %+v", res)
+	}
+
+	res = explain(t, s, &ReqSlbTask{TaskType: "FPGA"})
+	if res.RetCode != retCodeNoTaskServer {
+		t.Errorf("unknown type explain %+v", res)
+	}
+}
+
+func TestExplainDoesNotConsumeQuota(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU")
+	up := &UserPolicy{UserID: "u1", Rate: 1, Burst: 1, MaxConcurrent: 1}
+	s.usersPolicy = map[userID]*UserPolicy{"u1": up}
+	s.quotas = make(map[userID]*userQuota)
+	s.updateBalancers()
+
+	//解释走真实的选机流程，但不保存配额、不消耗令牌
+	for i := 0; i < 3; i++ {
+		res := explain(t, s, &ReqSlbTask{UserID: "u1", TaskType: string(CpuPro)})
+		if res.RetCode != retCodeSuccess || res.Choice != "192.168.1.1:80" || len(res.Trace) == 0 {
+			t.Fatalf("explain %d %+v", i, res)
+		}
+	}
+	if len(s.quotas) != 0 {
+		t.Fatalf("explain stored quota %v", s.quotas)
+	}
+
+	r := &ReqSlbTask{UserID: "u1", TaskType: string(CpuPro)}
+	if res := s.admitQuota(r, up, time.Now()); res != nil {
+		t.Fatalf("first real request rejected %+v", res)
+	}
+	res := explain(t, s, &ReqSlbTask{UserID: "u1", TaskType: string(CpuPro)})
+	if res.RetCode != retCodeConcurrencyLimited || s.quotas["u1"].active != 1 {
+		t.Errorf("explain with quota in use %+v", res)
+	}
+	r.hold.release()
+}
@@ -0,0 +1,96 @@
+package strategy
+
+//按用户公平排队：同一优先级内按用户轮流分发，一个用户提交大量请求时不会挡住同级别的其他用户。
+//使用自计时公平排队(SCFQ)，任务进入链表时计算虚拟完成时间：
+//	max(链表的虚拟时间, 此用户上一个任务的虚拟完成时间) + 1/用户权重
+//链表按虚拟完成时间排序，取走任务时链表的虚拟时间推进到此任务的虚拟完成时间。
+//用户权重来自用户策略的Weight，没有配置时为1，权重为2的用户得到的分发次数是权重为1的两倍
+import (
+	"container/list"
+)
+
+//没有配置权重的用户使用的权重
+const defaultFairWeight int = 1
+
+//按虚拟完成时间排序的任务链表，链表的读取和遍历直接使用list.List，
+//加入任务和取走任务需要使用push和take，调用者负责加锁
+type fairList struct {
+	*list.List
+	vtime  float64            //已取走任务的最大虚拟完成时间
+	finish map[string]float64 //每个用户最后一个任务的虚拟完成时间
+}
+
+func newFairList() *fairList {
+	return &fairList{List: list.New(), finish: make(map[string]float64)}
+}
+
+func (r *ReqSlbTask) fairWeight() float64 {
+	if r.weight <= 0 {
+		return float64(defaultFairWeight)
+	}
+	return float64(r.weight)
+}
+
+//任务排在前面：虚拟完成时间小的在前，相同时先进入队列的在前
+func fairBefore(a, b *ReqSlbTask) bool {
+	if a.fairTag != b.fairTag {
+		return a.fairTag < b.fairTag
+	}
+	return a.enqueueTime.Before(b.enqueueTime)
+}
+
+//按用户的虚拟完成时间加入任务
+func (l *fairList) push(task *ReqSlbTask) {
+	start := l.vtime
+	if last, ok := l.finish[task.UserID]; ok && last > start {
+		start = last
+	}
+	task.fairTag = start + 1/task.fairWeight()
+	l.finish[task.UserID] = task.fairTag
+	l.insert(task)
+}
+
+//提升了优先级的任务当作刚到达的任务排队，不排在此用户已有任务的后面，
+//这样等待过久的任务提升后很快就会被分发
+func (l *fairList) pushAged(task *ReqSlbTask) {
+	task.fairTag = l.vtime + 1/task.fairWeight()
+	if task.fairTag > l.finish[task.UserID] {
+		l.finish[task.UserID] = task.fairTag
+	}
+	l.insert(task)
+}
+
+//新任务的虚拟完成时间通常最大，从后往前找插入位置
+func (l *fairList) insert(task *ReqSlbTask) {
+	for e := l.Back(); e != nil; e = e.Prev() {
+		if !fairBefore(task, e.Value.(*ReqSlbTask)) {
+			l.InsertAfter(task, e)
+			return
+		}
+	}
+	l.PushFront(task)
+}
+
+//取走任务分发，推进虚拟时间。丢弃的任务直接Remove，不推进虚拟时间
+func (l *fairList) take(e *list.Element) *ReqSlbTask {
+	task := l.Remove(e).(*ReqSlbTask)
+	if task.fairTag > l.vtime {
+		l.vtime = task.fairTag
+	}
+	//链表为空时之前的用户记录已经没有意义
+	if l.Len() == 0 {
+		l.finish = make(map[string]float64)
+	}
+	return task
+}
+
+//清空链表，返回还在排队的任务
+func (l *fairList) drain() []*ReqSlbTask {
+	var pending []*ReqSlbTask
+	for e := l.Front(); e != nil; e = e.Next() {
+		pending = append(pending, e.Value.(*ReqSlbTask))
+	}
+	l.Init()
+	l.finish = make(map[string]float64)
+	return pending
+}
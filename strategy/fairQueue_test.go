@@ -0,0 +1,87 @@
+package strategy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+//依次取走链表中的任务，返回用户顺序
+func takeUsers(l *fairList) string {
+	users := ""
+	for e := l.Front(); e != nil; e = l.Front() {
+		users += l.take(e).UserID
+	}
+	return users
+}
+
+func TestFairListInterleavesUsers(t *testing.T) {
+
+	l := newFairList()
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		l.push(&ReqSlbTask{UserID: "a", enqueueTime: now})
+	}
+	l.push(&ReqSlbTask{UserID: "b", enqueueTime: now.Add(time.Millisecond)})
+	l.push(&ReqSlbTask{UserID: "c", enqueueTime: now.Add(2 * time.Millisecond)})
+
+	//a先提交的大量请求不会挡住b和c
+	if users := takeUsers(l); users != "abcaaa" {
+		t.Errorf("dispatch order %s, want abcaaa", users)
+	}
+}
+
+func TestFairListWeight(t *testing.T) {
+
+	l := newFairList()
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		l.push(&ReqSlbTask{UserID: "a", weight: 2, enqueueTime: now})
+		l.push(&ReqSlbTask{UserID: "b", enqueueTime: now})
+	}
+
+	//权重为2的用户分发次数是权重为1的用户的两倍
+	if users := takeUsers(l); strings.Count(users[:6], "a") != 4 {
+		t.Errorf("dispatch order %s, want 4 of a in first 6", users)
+	}
+}
+
+func TestFairListNewUserNotBehindBacklog(t *testing.T) {
+
+	l := newFairList()
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		l.push(&ReqSlbTask{UserID: "a", enqueueTime: now})
+	}
+	takeUsers(l)
+
+	//已经分发过的任务不影响之后到达的用户
+	for i := 0; i < 3; i++ {
+		l.push(&ReqSlbTask{UserID: "a", enqueueTime: now})
+	}
+	l.take(l.Front())
+	l.push(&ReqSlbTask{UserID: "b", enqueueTime: now})
+	if users := takeUsers(l); users != "aba" {
+		t.Errorf("dispatch order %s, want aba", users)
+	}
+}
+
+func TestPriorityQueueFairWithinLevel(t *testing.T) {
+
+	q := NewPriorityList(NewTaskCategoryList())
+	q.taskQueues.addCategory(CpuPro)
+	q.aging = 0
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		q.listAddAt(&ReqSlbTask{UserID: "a", TaskType: string(CpuPro), priority: priorityLeve5}, now)
+	}
+	q.listAddAt(&ReqSlbTask{UserID: "b", TaskType: string(CpuPro), priority: priorityLeve5}, now)
+	q.listAddAt(&ReqSlbTask{UserID: "c", TaskType: string(CpuPro), priority: priorityLeve6}, now)
+	q.dispatch(now)
+
+	//优先级之间仍然严格按优先级，同一优先级内按用户轮流
+	if users := takeUsers(q.taskQueues.categories[CpuPro].tasks); users != "cabaa" {
+		t.Errorf("dispatch order %s, want cabaa", users)
+	}
+}
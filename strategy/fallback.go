@@ -0,0 +1,116 @@
+package strategy
+
+//跨调度池降级：某种任务类型的调度池没有正常的服务器时，按配置的降级链到其他调度池选机，
+//例如GPU服务器全部宕机时由CPU服务器以较慢的速度处理。
+//降级链从配置文件[fallback]读取，key为任务类型，value为"调度池:系数"的列表，按顺序尝试：
+//	GPU=CPU:0.5
+//系数为0到1之间，表示降级请求在备用调度池每台服务器上最多使用的转码槽位比例(向上取整)，
+//剩余的槽位留给备用调度池自己的任务，避免降级流量把备用调度池压垮；不写系数时为1，不限制。
+//请求没有编码类型时，降级请求在每台服务器上同时处理的任务数不超过 权重*系数(向上取整)，
+//任务和槽位一样占用：doWork代理结束、租约释放或者selectServer的槽位到期时归还。
+//备用调度池还有空闲时降级请求都会被处理，不会按比例回复服务器忙
+import (
+	"common/utils"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type fallbackRule struct {
+	pool   taskProperty //降级到的调度池
+	factor float64      //降级请求在此调度池每台服务器上最多使用的槽位比例
+}
+
+//读取某种任务类型的降级链，格式不对的项忽略
+func readFallback(tp taskProperty) []*fallbackRule {
+
+	defer utils.DealPanic()
+	var rules []*fallbackRule
+	str := utils.ConfigFile.Read_string("fallback", string(tp), "")
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		rule := &fallbackRule{factor: 1}
+		fields := strings.SplitN(item, ":", 2)
+		rule.pool = taskProperty(strings.ToUpper(strings.TrimSpace(fields[0])))
+		if len(fields) == 2 {
+			factor, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+			if err != nil || factor <= 0 || factor > 1 {
+				utils.Log.Error("fallback %s:%s is not right, ignore", string(tp), item)
+				continue
+			}
+			rule.factor = factor
+		}
+		if rule.pool == tp {
+			utils.Log.Error("fallback %s can not fall back to itself, ignore", string(tp))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(rules) > 0 {
+		utils.Log.Debug("task type:%s fallback:%s", string(tp), str)
+	}
+	return rules
+}
+
+//降级请求在这台服务器上是否还有可用的槽位：最多使用槽位容量乘以系数(向上取整)，
+//没有编码类型时最多使用权重乘以系数(向上取整)个任务
+func (s *server) hasFallbackSlot(codec codecType, factor float64) bool {
+	if factor >= 1 {
+		return true
+	}
+
+	capacity := s.slotCapacity(codec)
+	if codec == "" {
+		capacity = s.weight
+	}
+	limit := int(math.Ceil(float64(capacity) * factor))
+	used := 0
+	for _, t := range *s.slotList(codec) {
+		if t.fallback {
+			used++
+		}
+	}
+	return used < limit
+}
+
+//调度池中是否有正常的服务器，调用者需要持有s.lock
+func (s *strategy) poolHealthy(tp taskProperty) bool {
+	for _, v := range s.poolServers(tp) {
+		if checkSeverState(v) {
+			return true
+		}
+	}
+	return false
+}
+
+//按降级链选机，调用者需要持有s.lock
+func (s *strategy) pickFallback(r *ReqSlbTask, tp taskProperty) (*server, error) {
+
+	for _, rule := range s.fallbacks[tp] {
+		b, ok := s.balancers[rule.pool]
+		if !ok || !s.poolHealthy(rule.pool) {
+			r.trace("fallback pool %s has no healthy server", string(rule.pool))
+			continue
+		}
+
+		//按系数限制降级请求在每台服务器上使用的槽位
+		r.fallbackFactor = rule.factor
+		ser, err := pickServer(b, r)
+		r.fallbackFactor = 0
+		if err != nil {
+			utils.Log.Debug("task type:%s fallback to %s has no free slot", string(tp), string(rule.pool))
+			r.trace("fallback pool %s has no available server, factor %v", string(rule.pool), rule.factor)
+			continue
+		}
+		r.trace("fall back to pool %s", string(rule.pool))
+		utils.Log.Info("task type:%s has no healthy server, fall back to %s:%s", string(tp), string(rule.pool), ser.ip)
+		return ser, nil
+	}
+	return nil, errors.New("no fallback server")
+}
@@ -0,0 +1,100 @@
+package strategy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFallbackToOtherPool(t *testing.T) {
+
+	s := newCategoryTestStrategy("GPU", "GPU", "CPU")
+	s.updateBalancers()
+	s.fallbacks[GpuPro] = []*fallbackRule{{pool: CpuPro, factor: 0.5}}
+
+	//GPU正常时不降级
+	r := &ReqSlbTask{TaskType: string(GpuPro)}
+	ser, code, err := s.getBackendServer(r)
+	if err != nil || poolOf(ser) != GpuPro || r.fallback {
+		t.Fatalf("pick with healthy gpu: code %d err %v fallback %v", code, err, r.fallback)
+	}
+
+	for _, v := range s.poolServers(GpuPro) {
+		v.state.Down = true
+	}
+	s.balancers[GpuPro].Rebuild(s.poolServers(GpuPro))
+
+	//系数0.5，降级请求最多使用CPU服务器一半的H264槽位，CPU池空闲时不会回复服务器忙
+	cpu := s.poolServers(CpuPro)[0]
+	cpu.H264Capacity = 4
+	for i := 0; i < 2; i++ {
+		r := &ReqSlbTask{TaskType: string(GpuPro), Codec: H264}
+		ser, code, err := s.getBackendServer(r)
+		if err != nil {
+			t.Fatalf("fallback %d: retCode %d err %v", i, code, err)
+		}
+		response := &responseSlbReq{}
+		s.occupy(r, ser, response)
+		if response.Pool != string(CpuPro) || !response.Fallback {
+			t.Fatalf("response pool %s fallback %v", response.Pool, response.Fallback)
+		}
+	}
+	if _, code, err := s.getBackendServer(&ReqSlbTask{TaskType: string(GpuPro), Codec: H264}); err == nil || code != retCodeServerBusy {
+		t.Fatalf("fallback beyond factor: retCode %d err %v", code, err)
+	}
+
+	//剩余的槽位留给CPU自己的任务
+	for i := 0; i < 2; i++ {
+		r := &ReqSlbTask{TaskType: string(CpuPro), Codec: H264}
+		ser, _, err := s.getBackendServer(r)
+		if err != nil || r.fallback {
+			t.Fatalf("cpu task %d: err %v fallback %v", i, err, r.fallback)
+		}
+		s.occupy(r, ser, &responseSlbReq{})
+	}
+
+}
+
+func TestFallbackWithoutCodec(t *testing.T) {
+
+	s := newCategoryTestStrategy("GPU", "CPU")
+	s.slotHold = time.Minute
+	s.updateBalancers()
+	s.fallbacks[GpuPro] = []*fallbackRule{{pool: CpuPro, factor: 0.5}}
+	s.servers["192.168.1.1"].state.Down = true
+	s.balancers[GpuPro].Rebuild(s.poolServers(GpuPro))
+	cpu := s.servers["192.168.1.2"]
+	cpu.weight = 4
+
+	//没有编码类型的降级请求最多同时处理 权重4*系数0.5 = 2个任务
+	var reqs []*ReqSlbTask
+	for i := 0; i < 2; i++ {
+		r := &ReqSlbTask{TaskType: string(GpuPro), ReqMode: DoWork}
+		ser, code, err := s.getBackendServer(r)
+		if err != nil || ser != cpu || !r.fallback {
+			t.Fatalf("fallback without codec %d: retCode %d err %v", i, code, err)
+		}
+		s.occupy(r, ser, &responseSlbReq{})
+		reqs = append(reqs, r)
+	}
+	if _, code, err := s.getBackendServer(&ReqSlbTask{TaskType: string(GpuPro)}); err == nil || code != retCodeServerBusy {
+		t.Fatalf("fallback without codec beyond factor: retCode %d err %v", code, err)
+	}
+
+	//CPU自己的请求不受限制，也不占用降级任务
+	for i := 0; i < 5; i++ {
+		r := &ReqSlbTask{TaskType: string(CpuPro)}
+		if ser, _, err := s.getBackendServer(r); err != nil || ser != cpu {
+			t.Fatalf("cpu task %d: err %v", i, err)
+		}
+		s.occupy(r, cpu, &responseSlbReq{})
+	}
+	if len(cpu.fallbackTasks) != 2 {
+		t.Fatalf("fallback tasks %d", len(cpu.fallbackTasks))
+	}
+
+	//降级任务结束后可以再降级
+	s.releaseOccupied(reqs[0])
+	if _, _, err := s.getBackendServer(&ReqSlbTask{TaskType: string(GpuPro)}); err != nil {
+		t.Errorf("fallback after release: %v", err)
+	}
+}
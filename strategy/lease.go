@@ -0,0 +1,154 @@
+package strategy
+
+//任务租约：selectServer模式下客户端可以申请租约，任务运行期间定时续约，任务结束后释放，
+//租约期间占用服务器的转码槽位并计入服务器正在处理的任务数，超时未续约的租约自动回收
+import (
+	"common/utils"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+type leaseAction string
+
+const (
+	LeaseRenew   = leaseAction("renew")   //续约
+	LeaseRelease = leaseAction("release") //释放
+)
+
+//租约默认时长和最大时长，单位秒
+const (
+	defaultLeaseTTLSeconds int = 60
+	maxLeaseTTLSeconds     int = 3600
+)
+
+type lease struct {
+	id     string        //租约ID
+	server *server       //租约所在服务器
+	slot   *taskSlot     //占用的转码槽位，请求没有编码类型时为nil
+	hold   *quotaHold    //接管的请求的用户并发配额
+	ttl    time.Duration //租约时长
+	expire time.Time     //到期时间
+}
+
+//续约或者释放租约时，客户端带的body参数
+type ReqLeaseTask struct {
+	Lease        string       //租约ID
+	Action       leaseAction  //"renew" 续约, "release" 释放
+	TTL          int          //续约时长，单位秒，为0时沿用申请时的时长
+	ResponseChan *chan string //针对每个请求的同步回复通道
+}
+
+//针对ReqLeaseTask的回复
+type responseLeaseReq struct {
+	RetCode retCode //成功此值是0，失败非0
+	Result  string  //结果描述
+	TTL     int     //续约后的租约时长，单位秒
+}
+
+func NewReqLeaseTask() *ReqLeaseTask {
+	r := &ReqLeaseTask{}
+	chanStr := make(chan string, 1)
+	r.ResponseChan = &chanStr
+	return r
+}
+
+//生成随机的租约ID
+func newLeaseID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		utils.Log.Error("newLeaseID err:%s", err.Error())
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf)
+}
+
+//租约时长：客户端指定的时长限制在最大值以内，没有指定时使用配置文件[lease]的ttl_seconds
+func (s *strategy) leaseTTL(ttl int) time.Duration {
+	if ttl <= 0 {
+		return s.defaultLeaseTTL
+	}
+	if ttl > maxLeaseTTLSeconds {
+		ttl = maxLeaseTTLSeconds
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+func readLeaseTTL() time.Duration {
+
+	defer utils.DealPanic()
+	ttl, err := strconv.Atoi(utils.ConfigFile.Read_string("lease", "ttl_seconds", strconv.Itoa(defaultLeaseTTLSeconds)))
+	if err != nil || ttl <= 0 || ttl > maxLeaseTTLSeconds {
+		utils.Log.Error("lease ttl_seconds is not right, use %d", defaultLeaseTTLSeconds)
+		ttl = defaultLeaseTTLSeconds
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+//在选中的服务器上创建租约，调用者需要持有s.lock
+func (s *strategy) newLease(r *ReqSlbTask, ser *server) *lease {
+
+	l := &lease{id: newLeaseID(), server: ser, ttl: s.leaseTTL(r.LeaseTTL), hold: r.hold}
+	l.expire = time.Now().Add(l.ttl)
+	//槽位由租约释放，不设置到期时间
+	l.slot = ser.acquireSlot(r, time.Time{})
+	ser.inflight++
+	s.leases[l.id] = l
+
+	utils.Log.Debug("new lease:%s server:%s ttl:%v", l.id, ser.ip, l.ttl)
+	return l
+}
+
+//释放租约占用的资源，重复释放无影响。调用者需要持有s.lock
+func (s *strategy) releaseLease(l *lease) {
+	if _, ok := s.leases[l.id]; !ok {
+		return
+	}
+	l.slot.release()
+	l.hold.release()
+	l.server.inflight--
+	delete(s.leases, l.id)
+}
+
+//回收到期未续约的租约，调用者需要持有s.lock
+func (s *strategy) reclaimLeases(now time.Time) {
+	for id, l := range s.leases {
+		if now.After(l.expire) {
+			utils.Log.Info("lease:%s on server:%s expired, reclaim", id, l.server.ip)
+			s.releaseLease(l)
+		}
+	}
+}
+
+//处理续约和释放租约请求
+func (s *strategy) dealLease(req *ReqLeaseTask) {
+	defer utils.DealPanic()
+
+	response := &responseLeaseReq{}
+	s.lock.Lock()
+	l, ok := s.leases[req.Lease]
+	if !ok {
+		response.RetCode = retCodeFail
+		response.Result = "fail,the lease is not exist or expired"
+	} else if req.Action == LeaseRenew {
+		if req.TTL > 0 {
+			l.ttl = s.leaseTTL(req.TTL)
+		}
+		l.expire = time.Now().Add(l.ttl)
+		response.RetCode = retCodeSuccess
+		response.Result = "success"
+		response.TTL = int(l.ttl / time.Second)
+	} else if req.Action == LeaseRelease {
+		s.releaseLease(l)
+		response.RetCode = retCodeSuccess
+		response.Result = "success"
+	} else {
+		response.RetCode = retCodeFail
+		response.Result = "fail,the action should be renew or release"
+	}
+	s.lock.Unlock()
+
+	utils.Log.Debug("deal lease:%s action:%s result:%s", req.Lease, string(req.Action), response.Result)
+	responseToClient(req.ResponseChan, response)
+}
@@ -0,0 +1,112 @@
+package strategy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func queryLease(t *testing.T, s *strategy, id string, action leaseAction, ttl int) *responseLeaseReq {
+	req := NewReqLeaseTask()
+	req.Lease, req.Action, req.TTL = id, action, ttl
+	s.dealLease(req)
+	res := &responseLeaseReq{}
+	if err := json.Unmarshal([]byte(<-*req.ResponseChan), res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func newLeaseTestStrategy() (*strategy, *server) {
+	s := newCategoryTestStrategy("CPU")
+	s.leases = make(map[string]*lease)
+	s.defaultLeaseTTL = time.Minute
+	s.updateBalancers()
+	ser := s.servers["192.168.1.1"]
+	ser.H264Capacity = 1
+	return s, ser
+}
+
+//申请租约的selectServer请求
+func occupyLease(s *strategy, ser *server, ttl int) (*ReqSlbTask, *responseSlbReq) {
+	r := &ReqSlbTask{TaskType: string(CpuPro), Codec: H264, Lease: true, LeaseTTL: ttl}
+	response := &responseSlbReq{}
+	s.occupy(r, ser, response)
+	return r, response
+}
+
+func TestLeaseRenewAndRelease(t *testing.T) {
+
+	s, ser := newLeaseTestStrategy()
+	r, response := occupyLease(s, ser, 0)
+	if response.Lease == "" || response.TTL != 60 || ser.inflight != 1 || ser.hasFreeSlot(H264) {
+		t.Fatalf("lease reply %+v, inflight %d", response, ser.inflight)
+	}
+	//租约的槽位不会按slotHold到期回收
+	if !r.lease.slot.expire.IsZero() {
+		t.Error("lease slot has an expire time")
+	}
+
+	if res := queryLease(t, s, response.Lease, LeaseRenew, 120); res.RetCode != retCodeSuccess || res.TTL != 120 {
+		t.Fatalf("renew %+v", res)
+	}
+	if l := s.leases[response.Lease]; l.expire.Before(time.Now().Add(110 * time.Second)) {
+		t.Errorf("renew did not extend expire %v", l.expire)
+	}
+
+	if res := queryLease(t, s, response.Lease, "stop", 0); res.RetCode != retCodeFail {
+		t.Errorf("unknown action %+v", res)
+	}
+	if res := queryLease(t, s, response.Lease, LeaseRelease, 0); res.RetCode != retCodeSuccess {
+		t.Fatalf("release %+v", res)
+	}
+	if ser.inflight != 0 || !ser.hasFreeSlot(H264) || len(s.leases) != 0 {
+		t.Errorf("release left inflight %d, slots %v", ser.inflight, ser.H264TaskSlice)
+	}
+
+	//重复释放失败，资源不会被多归还一次
+	if res := queryLease(t, s, response.Lease, LeaseRelease, 0); res.RetCode != retCodeFail {
+		t.Errorf("release twice %+v", res)
+	}
+	s.releaseOccupied(r)
+	if ser.inflight != 0 {
+		t.Errorf("inflight %d after double release", ser.inflight)
+	}
+}
+
+func TestLeaseExpire(t *testing.T) {
+
+	s, ser := newLeaseTestStrategy()
+	_, response := occupyLease(s, ser, 10)
+	if response.TTL != 10 {
+		t.Fatalf("lease ttl %d", response.TTL)
+	}
+
+	s.reclaimLeases(time.Now().Add(5 * time.Second))
+	if len(s.leases) != 1 {
+		t.Fatal("lease reclaimed before expire")
+	}
+
+	//到期未续约的租约自动回收，不能再续约
+	s.reclaimLeases(time.Now().Add(11 * time.Second))
+	if len(s.leases) != 0 || ser.inflight != 0 || !ser.hasFreeSlot(H264) {
+		t.Fatalf("expired lease not reclaimed, inflight %d", ser.inflight)
+	}
+	if res := queryLease(t, s, response.Lease, LeaseRenew, 0); res.RetCode != retCodeFail {
+		t.Errorf("renew expired lease %+v", res)
+	}
+}
+
+func TestLeaseTTL(t *testing.T) {
+
+	s := &strategy{defaultLeaseTTL: time.Minute}
+	for ttl, want := range map[int]time.Duration{
+		0:                      time.Minute,
+		30:                     30 * time.Second,
+		maxLeaseTTLSeconds + 1: time.Duration(maxLeaseTTLSeconds) * time.Second,
+	} {
+		if got := s.leaseTTL(ttl); got != want {
+			t.Errorf("leaseTTL(%d) = %v, want %v", ttl, got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package strategy
+
+import (
+	"common/utils"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+//最少请求调度：选择 正在处理的任务数/权重 最小的服务器，用于doWork模式或者申请租约的selectServer模式，
+//长时间的转码请求不会因为轮询指针恰好指向某台服务器而堆积在它上面。
+//开启p2c时随机取两台服务器，选其中请求数较少的一台，避免所有请求同时涌向同一台空闲服务器
+type leastConn struct {
+	servers []*server  //服务器列表
+	p2c     bool       //是否使用power of two choices
+	next    int        //请求数相同时轮流选择的起始位置
+	random  *rand.Rand //p2c使用的随机数
+}
+
+func init() {
+	registerBalancer(LeastConn, func(tp taskProperty) Balancer { return NewLeastConn(tp) })
+}
+
+//是否开启p2c从配置文件[least_conn]读取，key为"任务类型_p2c"
+func NewLeastConn(tp taskProperty) *leastConn {
+
+	defer utils.DealPanic()
+	l := &leastConn{random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	l.p2c = strings.ToLower(utils.ConfigFile.Read_string("least_conn", string(tp)+"_p2c", "false")) == "true"
+	utils.Log.Debug("task type:%s least conn p2c:%v", string(tp), l.p2c)
+	return l
+}
+
+//a的负载是否比b轻，比较 a.inflight/a.weight < b.inflight/b.weight，慢启动期间使用慢启动权重
+func lessLoaded(a, b *server, now time.Time) bool {
+	return a.inflight*b.rampedWeight(now) < b.inflight*a.rampedWeight(now)
+}
+
+func (l *leastConn) Pick(r *ReqSlbTask) (*server, error) {
+
+	defer utils.DealPanic()
+	now := time.Now()
+	var candidates []*server
+	for _, s := range l.servers {
+		if serverAvailable(s, r) && s.weight > 0 {
+			candidates = append(candidates, s)
+		}
+	}
+
+	if len(candidates) == 0 {
+		utils.Log.Debug(" no server")
+		return nil, errors.New("no server")
+	}
+
+	if l.p2c && len(candidates) > 2 {
+		i := l.random.Intn(len(candidates))
+		j := l.random.Intn(len(candidates) - 1)
+		if j >= i {
+			j++
+		}
+		if lessLoaded(candidates[j], candidates[i], now) {
+			return candidates[j], nil
+		}
+		return candidates[i], nil
+	}
+
+	l.next = (l.next + 1) % len(candidates)
+	best := candidates[l.next]
+	for k := 1; k < len(candidates); k++ {
+		s := candidates[(l.next+k)%len(candidates)]
+		if lessLoaded(s, best, now) {
+			best = s
+		}
+	}
+
+	return best, nil
+}
+
+func (l *leastConn) Rebuild(servers []*server) {
+	l.servers = servers
+}
+
+//p2c的随机选择无法预测，使用新的随机数，结果只是可能的一种
+func (l *leastConn) Peek(r *ReqSlbTask) (*server, error) {
+	peek := *l
+	peek.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return peek.Pick(r)
+}
@@ -0,0 +1,92 @@
+package strategy
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestLeastConnPicksLeastLoaded(t *testing.T) {
+
+	servers := newTestServers(1, 2, 1)
+	l := &leastConn{}
+	l.Rebuild(servers)
+
+	//按 正在处理的任务数/权重 选择：第2台权重为2，能比其他服务器多处理一倍的任务
+	for i := 0; i < 40; i++ {
+		s, err := l.Pick(&ReqSlbTask{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.inflight++
+	}
+	if servers[0].inflight != 10 || servers[1].inflight != 20 || servers[2].inflight != 10 {
+		t.Errorf("inflight %d:%d:%d, want 10:20:10", servers[0].inflight, servers[1].inflight, servers[2].inflight)
+	}
+
+	//任务结束后优先分配到空闲的服务器
+	servers[2].inflight = 3
+	if s, _ := l.Pick(&ReqSlbTask{}); s != servers[2] {
+		t.Errorf("picked %s, want %s", s.ip, servers[2].ip)
+	}
+}
+
+func TestLeastConnRotatesTies(t *testing.T) {
+
+	servers := newTestServers(1, 1, 1)
+	l := &leastConn{}
+	l.Rebuild(servers)
+
+	//请求数相同时轮流选择，不总是选第一台
+	seen := make(map[*server]bool)
+	for i := 0; i < 3; i++ {
+		s, _ := l.Pick(&ReqSlbTask{})
+		seen[s] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("ties picked %d servers, want 3", len(seen))
+	}
+}
+
+func TestLeastConnSkipsUnavailable(t *testing.T) {
+
+	servers := newTestServers(1, 1, 0)
+	servers[0].state.Down = true
+	l := &leastConn{}
+	l.Rebuild(servers)
+
+	//宕机和权重为0的服务器不分配
+	for i := 0; i < 5; i++ {
+		if s, err := l.Pick(&ReqSlbTask{}); err != nil || s != servers[1] {
+			t.Fatalf("picked %v, %v", s, err)
+		}
+	}
+	servers[1].state.Down = true
+	if _, err := l.Pick(&ReqSlbTask{}); err == nil {
+		t.Error("picked a server when none is available")
+	}
+}
+
+func TestLeastConnP2C(t *testing.T) {
+
+	servers := newTestServers(1, 1, 1, 1)
+	l := &leastConn{p2c: true, random: rand.New(rand.NewSource(1))}
+	l.Rebuild(servers)
+
+	//随机取两台中较轻的一台，负载最重的服务器不会被选中
+	servers[3].inflight = 100
+	for i := 0; i < 200; i++ {
+		s, err := l.Pick(&ReqSlbTask{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s == servers[3] {
+			t.Fatal("p2c picked the busiest server")
+		}
+		s.inflight++
+	}
+	for _, s := range servers[:3] {
+		if s.inflight < 50 || s.inflight > 85 {
+			t.Errorf("server %s got %d tasks", s.ip, s.inflight)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package strategy
+
+import (
+	"common/utils"
+	"errors"
+	"strconv"
+	"time"
+)
+
+//负载感知调度：按健康检查上报的CPU、内存、IO等待计算每台服务器的剩余能力，
+//剩余能力乘以配置的权重作为本轮的有效权重，再按平滑加权轮询分配请求，
+//服务器越忙分到的请求越少，而不是到90%时突然被摘除
+type loadAware struct {
+	servers       []*server       //服务器列表
+	cpuCoef       float64         //CPU使用率系数
+	memCoef       float64         //内存使用率系数
+	ioCoef        float64         //IO等待系数
+	currentWeight map[*server]int //平滑加权轮询的当前权重
+}
+
+func init() {
+	registerBalancer(LoadAware, func(tp taskProperty) Balancer { return NewLoadAware(tp) })
+}
+
+//系数从配置文件[load_aware]读取，key为"任务类型_cpu"、"任务类型_mem"、"任务类型_io"
+func NewLoadAware(tp taskProperty) *loadAware {
+
+	defer utils.DealPanic()
+	l := &loadAware{currentWeight: make(map[*server]int)}
+	l.cpuCoef = readLoadCoef(tp, "cpu", 0.6)
+	l.memCoef = readLoadCoef(tp, "mem", 0.3)
+	l.ioCoef = readLoadCoef(tp, "io", 0.1)
+	utils.Log.Debug("task type:%s load aware coef cpu:%v mem:%v io:%v", string(tp), l.cpuCoef, l.memCoef, l.ioCoef)
+	return l
+}
+
+func readLoadCoef(tp taskProperty, name string, def float64) float64 {
+
+	str := utils.ConfigFile.Read_string("load_aware", string(tp)+"_"+name, "")
+	if str == "" {
+		return def
+	}
+
+	coef, err := strconv.ParseFloat(str, 64)
+	if err != nil || coef < 0 {
+		utils.Log.Error("load_aware %s_%s:%s is not right, use %v", string(tp), name, str, def)
+		return def
+	}
+	return coef
+}
+
+//服务器的有效权重：配置权重(慢启动期间为慢启动权重) * (100 - 加权使用率) / 100
+func (l *loadAware) effectiveWeight(s *server, now time.Time) int {
+
+	weight := s.rampedWeight(now)
+	total := l.cpuCoef + l.memCoef + l.ioCoef
+	if total == 0 {
+		return weight
+	}
+
+	load := (l.cpuCoef*float64(s.state.CupUtil) + l.memCoef*float64(s.state.MemUtil) + l.ioCoef*float64(s.state.IoWait)) / total
+	if load >= 100 {
+		return 0
+	}
+	if load < 0 {
+		load = 0
+	}
+
+	return int(float64(weight) * (100 - load))
+}
+
+func (l *loadAware) Pick(r *ReqSlbTask) (*server, error) {
+
+	defer utils.DealPanic()
+	now := time.Now()
+	best := smoothPick(r, l.servers, l.currentWeight, func(s *server) int { return l.effectiveWeight(s, now) })
+	if best == nil {
+		utils.Log.Debug(" no server")
+		return nil, errors.New("no server")
+	}
+
+	return best, nil
+}
+
+func (l *loadAware) Rebuild(servers []*server) {
+	l.servers = servers
+	l.currentWeight = make(map[*server]int)
+}
+
+func (l *loadAware) Peek(r *ReqSlbTask) (*server, error) {
+	peek := *l
+	peek.currentWeight = copyWeights(l.currentWeight)
+	return peek.Pick(r)
+}
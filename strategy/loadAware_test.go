@@ -0,0 +1,78 @@
+package strategy
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLoadAware(servers []*server) *loadAware {
+	l := &loadAware{cpuCoef: 0.6, memCoef: 0.3, ioCoef: 0.1}
+	l.Rebuild(servers)
+	return l
+}
+
+func TestLoadAwareEffectiveWeight(t *testing.T) {
+
+	l := newTestLoadAware(nil)
+	l.cpuCoef, l.memCoef, l.ioCoef = 0.5, 0.25, 0.25
+	now := time.Now()
+	s := newTestServers(10)[0]
+
+	//加权使用率 0.5*60 + 0.25*20 + 0.25*40 = 45
+	s.state = &ServerState{CupUtil: 60, MemUtil: 20, IoWait: 40}
+	if w := l.effectiveWeight(s, now); w != 550 {
+		t.Errorf("effective weight %d, want 550", w)
+	}
+
+	s.state = &ServerState{CupUtil: 100, MemUtil: 100, IoWait: 100}
+	if w := l.effectiveWeight(s, now); w != 0 {
+		t.Errorf("full loaded effective weight %d, want 0", w)
+	}
+
+	//系数都为0时使用配置权重
+	l.cpuCoef, l.memCoef, l.ioCoef = 0, 0, 0
+	if w := l.effectiveWeight(s, now); w != 10 {
+		t.Errorf("zero coef effective weight %d, want 10", w)
+	}
+}
+
+func TestLoadAwareDistribution(t *testing.T) {
+
+	servers := newTestServers(1, 1, 1)
+	servers[0].state.CupUtil = 20
+	servers[1].state.CupUtil = 80
+	servers[2].state.CupUtil = 100
+	l := newTestLoadAware(servers)
+	l.memCoef, l.ioCoef = 0, 0
+
+	//有效权重80:20:0，平滑加权轮询每100次分配正好是80:20，满负载的服务器分不到请求
+	count := make(map[*server]int)
+	for i := 0; i < 100; i++ {
+		s, err := l.Pick(&ReqSlbTask{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		count[s]++
+	}
+	if count[servers[0]] != 80 || count[servers[1]] != 20 || count[servers[2]] != 0 {
+		t.Errorf("distribution %d:%d:%d, want 80:20:0", count[servers[0]], count[servers[1]], count[servers[2]])
+	}
+
+	servers[0].state.CupUtil, servers[1].state.CupUtil = 100, 100
+	if _, err := l.Pick(&ReqSlbTask{}); err == nil {
+		t.Error("picked a server when all are full loaded")
+	}
+}
+
+func TestLoadAwarePeek(t *testing.T) {
+
+	servers := newTestServers(3, 2, 1)
+	l := newTestLoadAware(servers)
+	for i := 0; i < 12; i++ {
+		peek, _ := l.Peek(&ReqSlbTask{})
+		pick, _ := l.Pick(&ReqSlbTask{})
+		if peek != pick {
+			t.Fatalf("pick %d peeked %s, picked %s", i, peek.ip, pick.ip)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package strategy
+
+//运行统计，计数同时写入日志，并在只读快照接口中返回
+import (
+	"common/utils"
+	"sync/atomic"
+	"time"
+)
+
+type slbStats struct {
+	TimeoutDropped       int64 //engine已经放弃等待(超时或者客户端断开)而被丢弃的请求数
+	Ejections            int64 //被动健康检查摘除服务器的次数
+	Retries              int64 //doWork模式换服务器重试的次数
+	RetryBudgetExhausted int64 //重试预算用完而放弃重试的次数
+	RateLimited          int64 //超过用户请求速率被拒绝的次数
+	ConcurrencyLimited   int64 //超过用户并发任务数被拒绝的次数
+}
+
+var stats slbStats
+
+//读取当前的统计，计数在多个协程中增加，使用原子操作读取
+func loadStats() slbStats {
+	return slbStats{
+		TimeoutDropped:       atomic.LoadInt64(&stats.TimeoutDropped),
+		Ejections:            atomic.LoadInt64(&stats.Ejections),
+		Retries:              atomic.LoadInt64(&stats.Retries),
+		RetryBudgetExhausted: atomic.LoadInt64(&stats.RetryBudgetExhausted),
+		RateLimited:          atomic.LoadInt64(&stats.RateLimited),
+		ConcurrencyLimited:   atomic.LoadInt64(&stats.ConcurrencyLimited),
+	}
+}
+
+//记录一个被丢弃的超时请求，where为丢弃的位置。被丢弃的请求同时归还用户的并发配额
+func countTimeout(r *ReqSlbTask, where string) {
+	r.hold.release()
+	n := atomic.AddInt64(&stats.TimeoutDropped, 1)
+	utils.Log.Info("slb req timeout, drop in %s, user:%s type:%s wait:%v, timeout total:%d", where, r.UserID, r.TaskType, time.Since(r.createTime), n)
+}
+
+//记录一个超过用户配额被拒绝的请求，counter为此类拒绝的计数。解释选机时不计数
+func countRejected(r *ReqSlbTask, kind string, counter *int64) {
+	if r.dryRun() {
+		return
+	}
+	n := atomic.AddInt64(counter, 1)
+	utils.Log.Info("slb req rejected by user %s limit, user:%s type:%s, total:%d", kind, r.UserID, r.TaskType, n)
+}
@@ -0,0 +1,114 @@
+package strategy
+
+//被动健康检查：doWork模式下代理到后端服务器连续失败(连接失败或者返回5xx)达到次数后，
+//把服务器摘除一段时间，不必等健康检查过几个周期才发现。
+//摘除时长从base_eject_seconds开始，每次再被摘除翻倍，最长max_eject_seconds，
+//服务器恢复后正常运行超过最长摘除时长，再被摘除时重新从base_eject_seconds开始
+import (
+	"common/utils"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultConsecutiveFailures int = 5
+	defaultBaseEjectSeconds    int = 10
+	defaultMaxEjectSeconds     int = 300
+)
+
+type outlierConfig struct {
+	consecutiveFailures int           //连续失败多少次摘除，0表示不做被动健康检查
+	baseEject           time.Duration //第一次摘除的时长
+	maxEject            time.Duration //最长摘除时长
+}
+
+func readOutlierInt(key string, def int) int {
+	v, err := strconv.Atoi(utils.ConfigFile.Read_string("outlier", key, strconv.Itoa(def)))
+	if err != nil || v < 0 {
+		utils.Log.Error("outlier %s is not right, use %d", key, def)
+		v = def
+	}
+	return v
+}
+
+//从配置文件[outlier]读取
+func readOutlierConfig() outlierConfig {
+
+	defer utils.DealPanic()
+	c := outlierConfig{}
+	c.consecutiveFailures = readOutlierInt("consecutive_failures", defaultConsecutiveFailures)
+	c.baseEject = time.Duration(readOutlierInt("base_eject_seconds", defaultBaseEjectSeconds)) * time.Second
+	c.maxEject = time.Duration(readOutlierInt("max_eject_seconds", defaultMaxEjectSeconds)) * time.Second
+	if c.baseEject <= 0 {
+		c.baseEject = time.Duration(defaultBaseEjectSeconds) * time.Second
+	}
+	if c.maxEject < c.baseEject {
+		c.maxEject = c.baseEject
+	}
+	return c
+}
+
+//服务器是否处于摘除期间
+func (s *server) ejected(now time.Time) bool {
+	return now.Before(s.ejectUntil)
+}
+
+//记录一次doWork代理结果，连续失败达到次数时摘除服务器，返回是否新摘除。调用者需要持有s.lock
+func (s *strategy) observe(ser *server, ok bool, now time.Time) bool {
+
+	if ok {
+		ser.consecutiveFails = 0
+		return false
+	}
+
+	ser.consecutiveFails++
+	if s.outlier.consecutiveFailures <= 0 || ser.consecutiveFails < s.outlier.consecutiveFailures || ser.ejected(now) {
+		return false
+	}
+
+	//恢复后稳定运行了足够长的时间，摘除时长重新计算
+	if now.Sub(ser.ejectUntil) > s.outlier.maxEject {
+		ser.ejectCount = 0
+	}
+
+	eject := s.outlier.baseEject
+	for i := 0; i < ser.ejectCount && eject < s.outlier.maxEject; i++ {
+		eject *= 2
+	}
+	if eject > s.outlier.maxEject {
+		eject = s.outlier.maxEject
+	}
+
+	ser.ejectCount++
+	ser.consecutiveFails = 0
+	ser.ejectUntil = now.Add(eject)
+	ser.restored = false
+	n := atomic.AddInt64(&stats.Ejections, 1)
+	utils.Log.Error("server:%s failed %d times in a row, eject %v (ejection #%d), ejections total:%d",
+		ser.ip, s.outlier.consecutiveFailures, eject, ser.ejectCount, n)
+
+	s.rebuildPool(poolOf(ser))
+	return true
+}
+
+//摘除到期的服务器重新加入调度池，并从慢启动开始。调用者需要持有s.lock
+func (s *strategy) restoreEjected(now time.Time) {
+	for _, v := range s.servers {
+		if v.ejectUntil.IsZero() || v.ejected(now) || v.restored {
+			continue
+		}
+
+		v.restored = true
+		v.upSince = now
+		utils.Log.Info("server:%s ejection expired, back to pool", v.ip)
+		s.rebuildPool(poolOf(v))
+	}
+}
+
+//重建调度池的负载均衡数据，调用者需要持有s.lock
+func (s *strategy) rebuildPool(tp taskProperty) {
+	if b, ok := s.balancers[tp]; ok {
+		b.Rebuild(s.poolServers(tp))
+	}
+}
@@ -0,0 +1,62 @@
+package strategy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlierEjectExponential(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU")
+	s.outlier = outlierConfig{consecutiveFailures: 3, baseEject: 10 * time.Second, maxEject: 30 * time.Second}
+	s.updateBalancers()
+	bad := s.poolServers(CpuPro)[0]
+	now := time.Now()
+
+	//成功会清零连续失败次数
+	s.observe(bad, false, now)
+	s.observe(bad, false, now)
+	s.observe(bad, true, now)
+	s.observe(bad, false, now)
+	s.observe(bad, false, now)
+	if checkSeverState(bad) == false {
+		t.Fatal("server ejected before consecutive failures reached")
+	}
+
+	//摘除时长 10s 20s 30s(最长) 30s
+	for i, want := range []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		if i > 0 {
+			s.observe(bad, false, now)
+			s.observe(bad, false, now)
+		}
+		if !s.observe(bad, false, now) {
+			t.Fatalf("ejection %d not triggered", i+1)
+		}
+		if got := bad.ejectUntil.Sub(now); got != want {
+			t.Errorf("ejection %d lasts %v, want %v", i+1, got, want)
+		}
+		if checkSeverState(bad) {
+			t.Fatalf("ejected server still available")
+		}
+		for j := 0; j < 10; j++ {
+			if ser, _ := s.balancers[CpuPro].Pick(&ReqSlbTask{}); ser == bad {
+				t.Fatal("picked ejected server")
+			}
+		}
+
+		s.restoreEjected(bad.ejectUntil)
+		now = bad.ejectUntil
+		if !bad.restored || !bad.upSince.Equal(now) {
+			t.Fatal("ejected server not restored")
+		}
+	}
+
+	//恢复后稳定运行超过最长摘除时长，重新从最短时长开始
+	now = now.Add(time.Minute)
+	s.observe(bad, false, now)
+	s.observe(bad, false, now)
+	s.observe(bad, false, now)
+	if got := bad.ejectUntil.Sub(now); got != 10*time.Second {
+		t.Errorf("ejection after stable period lasts %v, want 10s", got)
+	}
+}
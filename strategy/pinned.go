@@ -0,0 +1,167 @@
+package strategy
+
+//固定服务器的用户：用户策略可以按顺序指定多台服务器，请求分配到第一台可用的服务器。
+//都不可用时按Fallback处理：fail 回复失败；schedule 按用户优先级正常调度。doWork模式的重试也按相同的规则选机
+import (
+	"common/utils"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//正则匹配 1~255.0~255.0~255.0~255
+var ipPattern = regexp.MustCompile("^(1\\d{2}|2[0-4]\\d|25[0-5]|[1-9]\\d|[1-9])\\." + "(1\\d{2}|2[0-4]\\d|25[0-5]|[1-9]\\d|\\d)\\." + "(1\\d{2}|2[0-4]\\d|25[0-5]|[1-9]\\d|\\d)\\." + "(1\\d{2}|2[0-4]\\d|25[0-5]|[1-9]\\d|\\d)$")
+
+type pinnedFallback string
+
+const (
+	PinnedFallbackFail     = pinnedFallback("fail")     //固定服务器都不可用时回复失败
+	PinnedFallbackSchedule = pinnedFallback("schedule") //固定服务器都不可用时正常调度
+)
+
+//统一固定服务器列表：只填写了Ip时作为只有一台服务器的列表，Ip总是列表中的第一台
+func (up *UserPolicy) normalizePinned() {
+	if len(up.Ips) == 0 && up.Ip != "" {
+		up.Ips = []serverIP{up.Ip}
+	}
+	if len(up.Ips) > 0 {
+		up.Ip = up.Ips[0]
+	}
+	if up.Fallback == "" {
+		up.Fallback = PinnedFallbackFail
+	}
+}
+
+//数据库中的固定服务器列表以逗号分隔
+func joinPinned(ips []serverIP) string {
+	strs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		strs = append(strs, string(ip))
+	}
+	return strings.Join(strs, ",")
+}
+
+func splitPinned(str string) []serverIP {
+	var ips []serverIP
+	for _, ip := range strings.Split(str, ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			ips = append(ips, serverIP(ip))
+		}
+	}
+	return ips
+}
+
+//检查固定服务器列表，返回错误描述，没有错误时返回空
+func (s *strategy) checkPinned(up *UserPolicy) string {
+
+	if up.Fallback != PinnedFallbackFail && up.Fallback != PinnedFallbackSchedule {
+		return "fail,the Fallback should be fail or schedule"
+	}
+
+	seen := make(map[serverIP]bool)
+	for _, ip := range up.Ips {
+		if !ipPattern.MatchString(string(ip)) {
+			utils.Log.Debug("IP is not right: %s", string(ip))
+			return "fail,the ip style is not right"
+		}
+		//看看用户设置的IP是不是在服务器集合里面
+		if _, ok := s.servers[ip]; !ok {
+			utils.Log.Debug("the user  IP is not in the servers list: %s", string(ip))
+			return "fail,the user`s IP is not in the servers list"
+		}
+		if seen[ip] {
+			return "fail,the user`s IP is repeated"
+		}
+		seen[ip] = true
+	}
+	return ""
+}
+
+//按顺序选择第一台可用的固定服务器，没有可用服务器时返回nil和失败的错误码。调用者需要持有s.lock
+func (s *strategy) pickPinned(r *ReqSlbTask) (*server, retCode) {
+
+	code := retCodeFail
+	for _, ip := range r.pinned {
+		server := s.servers[ip]
+		//如果指定的服务器已经从配置中删除，那么server就是空
+		if nil == server {
+			utils.Log.Debug(" the userID ip(%s) did not in serverlist", ip)
+			r.trace("pinned server %s is not in the servers list", string(ip))
+			continue
+		}
+		if checkSeverState(server) && !server.hasFreeSlot(r.Codec) {
+			code = retCodeServerFull
+			utils.Log.Debug(" the userID ip(%s) has no free %s slot", ip, string(r.Codec))
+			r.trace("pinned server %s skipped: no free %s slot", string(ip), string(r.Codec))
+			continue
+		}
+		if !serverAvailable(server, r) {
+			r.trace("pinned server %s skipped: %s", string(ip), unavailableReason(server, r, time.Now()))
+			continue
+		}
+
+		r.trace("pinned server %s chosen", string(ip))
+		return server, retCodeSuccess
+	}
+	return nil, code
+}
+
+//按顺序把请求分配到第一台可用的固定服务器，已经回复客户端时返回true，
+//需要正常调度时返回false
+func (s *strategy) dealPinned(r *ReqSlbTask, up *UserPolicy) bool {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r.cancelled() {
+		countTimeout(r, "policy")
+		return true
+	}
+
+	//记录固定服务器列表，doWork重试时按相同的策略选机
+	r.pinned, r.pinnedFallback = up.Ips, up.Fallback
+	response := &responseSlbReq{RetCode: retCodeFail}
+	if validCodec(r.Codec) {
+		server, code := s.pickPinned(r)
+		if server != nil {
+			s.occupy(r, server, response)
+			response.RetCode = retCodeSuccess
+			response.IP = server.ip + ":" + strconv.Itoa(server.port)
+			s.slbResponseToClient(r, response)
+			return true
+		}
+		response.RetCode = code
+
+		if up.Fallback == PinnedFallbackSchedule {
+			utils.Log.Debug(" the userID(%s) pinned servers are not available, schedule normally", up.UserID)
+			r.trace("no pinned server available, schedule normally")
+			return false
+		}
+		r.trace("no pinned server available, fallback is %s", string(up.Fallback))
+	} else {
+		r.trace("codec %s is not supported", string(r.Codec))
+	}
+
+	s.slbResponseToClient(r, response)
+	return true
+}
+
+//重试时按第一次选机相同的策略选机：固定服务器的用户只在没有试过的固定服务器中选择，
+//Fallback为schedule时才到调度池选机。调用者需要持有s.lock
+func (s *strategy) retryServer(r *ReqSlbTask) (*server, retCode, error) {
+
+	if len(r.pinned) == 0 {
+		return s.getBackendServer(r)
+	}
+
+	server, code := s.pickPinned(r)
+	if server != nil {
+		return server, retCodeSuccess, nil
+	}
+	if r.pinnedFallback != PinnedFallbackSchedule {
+		return nil, code, errors.New("no other pinned server")
+	}
+	return s.getBackendServer(r)
+}
@@ -0,0 +1,88 @@
+package strategy
+
+import (
+	"testing"
+)
+
+func pinnedReq() (*ReqSlbTask, chan *responseSlbReq) {
+	chanRes := make(chan *responseSlbReq, 1)
+	r := &ReqSlbTask{TaskType: string(CpuPro), ReqMode: DoWork, ResponseChan: &chanRes}
+	return r, chanRes
+}
+
+func TestPinnedOrderedPreference(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU", "CPU")
+	s.updateBalancers()
+	up := &UserPolicy{UserID: "u1", Ips: []serverIP{"192.168.1.3", "192.168.1.1"}}
+	up.normalizePinned()
+	if up.Ip != "192.168.1.3" || up.Fallback != PinnedFallbackFail {
+		t.Fatalf("normalized policy %+v", up)
+	}
+
+	r, chanRes := pinnedReq()
+	if !s.dealPinned(r, up) {
+		t.Fatal("pinned request scheduled normally")
+	}
+	if res := <-chanRes; res.RetCode != retCodeSuccess || res.IP != "192.168.1.3:80" {
+		t.Fatalf("first choice reply %+v", res)
+	}
+
+	//第一台不可用时使用列表中的下一台
+	s.servers["192.168.1.3"].state.Down = true
+	r, chanRes = pinnedReq()
+	s.dealPinned(r, up)
+	if res := <-chanRes; res.RetCode != retCodeSuccess || res.IP != "192.168.1.1:80" {
+		t.Fatalf("second choice reply %+v", res)
+	}
+}
+
+func TestPinnedFallback(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU")
+	s.updateBalancers()
+	s.servers["192.168.1.1"].state.Down = true
+
+	//都不可用时回复失败
+	up := &UserPolicy{UserID: "u1", Ip: "192.168.1.1"}
+	up.normalizePinned()
+	r, chanRes := pinnedReq()
+	if !s.dealPinned(r, up) {
+		t.Fatal("fail policy scheduled normally")
+	}
+	if res := <-chanRes; res.RetCode != retCodeFail {
+		t.Fatalf("fail policy reply %+v", res)
+	}
+
+	//都不可用时正常调度，不回复
+	up.Fallback = PinnedFallbackSchedule
+	r, chanRes = pinnedReq()
+	if s.dealPinned(r, up) {
+		t.Fatal("schedule policy answered the request")
+	}
+	if len(chanRes) != 0 || r.target != nil {
+		t.Fatal("schedule policy occupied a server")
+	}
+}
+
+func TestCheckPinned(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU")
+	for _, up := range []*UserPolicy{
+		{Ips: []serverIP{"192.168.1.1", "192.168.1.9"}},
+		{Ips: []serverIP{"192.168.1.1", "192.168.1.1"}},
+		{Ips: []serverIP{"192.168.1"}},
+		{Ips: []serverIP{"192.168.1.2"}, Fallback: "retry"},
+	} {
+		up.normalizePinned()
+		if s.checkPinned(up) == "" {
+			t.Errorf("invalid pinned list accepted: %+v", up)
+		}
+	}
+
+	up := &UserPolicy{Ips: splitPinned(" 192.168.1.2, 192.168.1.1,")}
+	up.normalizePinned()
+	if msg := s.checkPinned(up); msg != "" || joinPinned(up.Ips) != "192.168.1.2,192.168.1.1" {
+		t.Errorf("valid pinned list: %s %v", msg, up.Ips)
+	}
+}
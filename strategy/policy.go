@@ -0,0 +1,161 @@
+package strategy
+
+//用户策略的查询和删除：GET 按用户ID查询一条或者分页列出全部策略，DELETE 删除用户策略。
+//修改和删除都先写数据库，数据库成功后再修改内存中的策略，两者保持一致
+import (
+	"common/utils"
+	"sort"
+)
+
+//分页列出策略时默认和最多返回的条数
+const (
+	defaultPolicyPageLimit int = 100
+	maxPolicyPageLimit     int = 1000
+)
+
+//用户策略的持久化，默认为数据库slb_policy表
+type policyStore interface {
+	QueryAndInsertPolicyTab(userid string, priority int, ip string, fallback string, rate float64, burst int, maxConcurrent int, weight int) (error, int)
+	DeletePolicyTab(userid string) (error, int)
+}
+
+type policyAction string
+
+const (
+	PolicyGet    = policyAction("get")    //查询一个用户的策略
+	PolicyList   = policyAction("list")   //分页列出全部策略
+	PolicyDelete = policyAction("delete") //删除用户策略
+)
+
+//查询或者删除用户策略时，客户端带的参数
+type ReqPolicyTask struct {
+	Action       policyAction //操作
+	UserID       string       //用户ID，查询一条和删除时使用
+	Offset       int          //分页列出时跳过的条数
+	Limit        int          //分页列出时返回的条数，0表示默认条数
+	ResponseChan *chan string //针对每个请求的同步回复通道
+}
+
+//回复给客户端的策略，不包含回复通道
+type policyInfo struct {
+	UserID        string         //用户id
+	Priority      int            //用户优先级
+	Ip            serverIP       //固定服务器列表中的第一台
+	Ips           []serverIP     //固定服务器列表
+	Fallback      pinnedFallback //固定服务器都不可用时的处理
+	Rate          float64        //每秒最多的请求数
+	Burst         int            //令牌桶容量
+	MaxConcurrent int            //同时进行的最多任务数
+	Weight        int            //同一优先级内公平排队的权重
+}
+
+//针对ReqPolicyTask的回复
+type responsePolicyListReq struct {
+	RetCode  retCode      //成功此值是0，失败非0
+	Result   string       //结果描述
+	Total    int          //策略总数
+	Offset   int          //本页的起始位置
+	Policies []policyInfo //本页的策略
+}
+
+func NewReqPolicyTask() *ReqPolicyTask {
+	r := &ReqPolicyTask{}
+	chanStr := make(chan string, 1)
+	r.ResponseChan = &chanStr
+	return r
+}
+
+func (up *UserPolicy) info() policyInfo {
+	return policyInfo{
+		UserID:        up.UserID,
+		Priority:      up.Priority,
+		Ip:            up.Ip,
+		Ips:           up.Ips,
+		Fallback:      up.Fallback,
+		Rate:          up.Rate,
+		Burst:         up.Burst,
+		MaxConcurrent: up.MaxConcurrent,
+		Weight:        up.Weight,
+	}
+}
+
+//优先级是否在0-9之间
+func validPriority(priority int) bool {
+	return priority >= int(priorityLeve0) && priority <= int(priorityLeve9)
+}
+
+//处理查询和删除用户策略的请求
+func (s *strategy) dealPolicy(req *ReqPolicyTask) {
+	defer utils.DealPanic()
+
+	response := &responsePolicyListReq{RetCode: retCodeSuccess, Result: "success"}
+	switch req.Action {
+	case PolicyGet:
+		up, ok := s.usersPolicy[userID(req.UserID)]
+		if !ok {
+			response.RetCode = retCodeFail
+			response.Result = "fail,the user policy does not exist"
+			break
+		}
+		response.Total = 1
+		response.Policies = []policyInfo{up.info()}
+	case PolicyList:
+		s.listPolicy(req, response)
+	case PolicyDelete:
+		s.deletePolicy(req.UserID, response)
+	default:
+		response.RetCode = retCodeFail
+		response.Result = "fail,the action should be get, list or delete"
+	}
+
+	utils.Log.Debug("deal policy action:%s user:%s result:%s", string(req.Action), req.UserID, response.Result)
+	responseToClient(req.ResponseChan, response)
+}
+
+//按用户ID排序分页
+func (s *strategy) listPolicy(req *ReqPolicyTask, response *responsePolicyListReq) {
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultPolicyPageLimit
+	} else if limit > maxPolicyPageLimit {
+		limit = maxPolicyPageLimit
+	}
+	offset := req.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	ids := make([]string, 0, len(s.usersPolicy))
+	for id := range s.usersPolicy {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	response.Total = len(ids)
+	response.Offset = offset
+	response.Policies = []policyInfo{}
+	for i := offset; i < len(ids) && i < offset+limit; i++ {
+		response.Policies = append(response.Policies, s.usersPolicy[userID(ids[i])].info())
+	}
+}
+
+//先从数据库删除，成功后再删除内存中的策略和配额状态
+func (s *strategy) deletePolicy(id string, response *responsePolicyListReq) {
+
+	if _, ok := s.usersPolicy[userID(id)]; !ok {
+		response.RetCode = retCodeFail
+		response.Result = "fail,the user policy does not exist"
+		return
+	}
+
+	if err, _ := s.policyStore.DeletePolicyTab(id); err != nil {
+		response.RetCode = retCodeFail
+		response.Result = "fail,delete from db failed"
+		return
+	}
+
+	delete(s.usersPolicy, userID(id))
+	delete(s.quotas, userID(id))
+	utils.Log.Info("delete user policy:%s", id)
+}
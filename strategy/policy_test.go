@@ -0,0 +1,126 @@
+package strategy
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+//保存在内存中的策略表，fail为true时模拟数据库失败
+type memPolicyStore struct {
+	rows map[string]int
+	fail bool
+}
+
+func (m *memPolicyStore) QueryAndInsertPolicyTab(userid string, priority int, ip string, fallback string, rate float64, burst int, maxConcurrent int, weight int) (error, int) {
+	if m.fail {
+		return errors.New("db down"), 1
+	}
+	m.rows[userid] = priority
+	return nil, 0
+}
+
+func (m *memPolicyStore) DeletePolicyTab(userid string) (error, int) {
+	if m.fail {
+		return errors.New("db down"), 1
+	}
+	delete(m.rows, userid)
+	return nil, 0
+}
+
+func newPolicyTestStrategy() (*strategy, *memPolicyStore) {
+	s := newCategoryTestStrategy("CPU", "CPU")
+	s.usersPolicy = make(map[userID]*UserPolicy)
+	s.quotas = make(map[userID]*userQuota)
+	store := &memPolicyStore{rows: make(map[string]int)}
+	s.policyStore = store
+	return s, store
+}
+
+func putPolicy(t *testing.T, s *strategy, up *UserPolicy) *responsePolicyReq {
+	chanStr := make(chan string, 1)
+	up.ResponseChan = &chanStr
+	s.dealUpdateUserPolicy(up)
+	res := &responsePolicyReq{}
+	if err := json.Unmarshal([]byte(<-chanStr), res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func queryPolicy(t *testing.T, s *strategy, req *ReqPolicyTask) *responsePolicyListReq {
+	chanStr := make(chan string, 1)
+	req.ResponseChan = &chanStr
+	s.dealPolicy(req)
+	res := &responsePolicyListReq{}
+	if err := json.Unmarshal([]byte(<-chanStr), res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestPolicyCrud(t *testing.T) {
+
+	s, store := newPolicyTestStrategy()
+	for _, id := range []string{"c", "a", "b"} {
+		if res := putPolicy(t, s, &UserPolicy{UserID: id, Priority: 3}); res.RetCode != retCodeSuccess {
+			t.Fatalf("put %s: %+v", id, res)
+		}
+	}
+
+	res := queryPolicy(t, s, &ReqPolicyTask{Action: PolicyList, Offset: 1, Limit: 1})
+	if res.Total != 3 || len(res.Policies) != 1 || res.Policies[0].UserID != "b" {
+		t.Fatalf("list page %+v", res)
+	}
+
+	res = queryPolicy(t, s, &ReqPolicyTask{Action: PolicyGet, UserID: "a"})
+	if res.RetCode != retCodeSuccess || res.Policies[0].Priority != 3 {
+		t.Fatalf("get %+v", res)
+	}
+
+	res = queryPolicy(t, s, &ReqPolicyTask{Action: PolicyDelete, UserID: "a"})
+	if res.RetCode != retCodeSuccess || s.usersPolicy["a"] != nil || len(store.rows) != 2 {
+		t.Fatalf("delete %+v, rows %v", res, store.rows)
+	}
+	if res = queryPolicy(t, s, &ReqPolicyTask{Action: PolicyGet, UserID: "a"}); res.RetCode != retCodeFail {
+		t.Errorf("get deleted %+v", res)
+	}
+	if res = queryPolicy(t, s, &ReqPolicyTask{Action: PolicyDelete, UserID: "a"}); res.RetCode != retCodeFail {
+		t.Errorf("delete twice %+v", res)
+	}
+}
+
+func TestPolicyValidation(t *testing.T) {
+
+	s, store := newPolicyTestStrategy()
+	for _, up := range []*UserPolicy{
+		{UserID: "u1", Priority: 10},
+		{UserID: "u1", Priority: -1},
+		{UserID: "u1", Ip: "192.168.1.9"},
+	} {
+		if res := putPolicy(t, s, up); res.RetCode != retCodeFail {
+			t.Errorf("invalid policy %+v accepted", up)
+		}
+	}
+	if len(s.usersPolicy) != 0 || len(store.rows) != 0 {
+		t.Fatal("invalid policy stored")
+	}
+}
+
+func TestPolicyConsistentWhenDbFails(t *testing.T) {
+
+	s, store := newPolicyTestStrategy()
+	putPolicy(t, s, &UserPolicy{UserID: "u1", Priority: 2})
+
+	//数据库失败时内存中的策略不变
+	store.fail = true
+	if res := putPolicy(t, s, &UserPolicy{UserID: "u1", Priority: 8}); res.RetCode != retCodeFail {
+		t.Fatalf("put with db down %+v", res)
+	}
+	if res := queryPolicy(t, s, &ReqPolicyTask{Action: PolicyDelete, UserID: "u1"}); res.RetCode != retCodeFail {
+		t.Fatalf("delete with db down %+v", res)
+	}
+	if up := s.usersPolicy["u1"]; up == nil || up.Priority != 2 || store.rows["u1"] != 2 {
+		t.Errorf("memory %+v and db %v diverged", up, store.rows)
+	}
+}
@@ -4,46 +4,109 @@ package strategy
 import (
 	"common/utils"
 	"container/list"
+	"strconv"
 	"sync"
 	"time"
 )
 
 const MAX_PRIORITY_NUM = 10
 
+//每次最多分发的任务数，防止分发协程持续运行时间过久
+const maxDealReqNum int = 1024
+
+//优先级队列默认最多排队的请求数
+const defaultPriorityQueueCapacity int = 10000
+
+//默认每等待500毫秒提升一级优先级，最低级别的任务最多约4.5秒升到最高级别
+const defaultAgingMs int = 500
+
+//每个优先级别(按用户原始优先级统计)的等待时间
+type levelWaitStat struct {
+	count   int64         //已分发的任务数
+	total   time.Duration //总等待时间
+	maxWait time.Duration //最长等待时间
+}
+
 //不同级别优先级的任务放入到不同级别的都列
 type priorityQueue struct {
 	lock       sync.Mutex
-	lists      [MAX_PRIORITY_NUM]*list.List //0-9个链表，表明优先级为0-9,0优先级最低，9优先级最高
-	taskQueues *taskCategoryQueue           //多类型任务队列
+	lists      [MAX_PRIORITY_NUM]*fairList //0-9个链表，表明优先级为0-9,0优先级最低，9优先级最高，同一链表内按用户公平排队
+	taskQueues *taskCategoryQueue          //多类型任务队列
 	quitChan   chan bool
+	notify     chan struct{}                   //有新任务或者任务属性队列有空位时通知分发协程
+	capacity   int                             //最多排队的请求数
+	queued     int                             //正在排队的请求数
+	aging      time.Duration                   //等待多久提升一级优先级，为0时不提升
+	waitStats  [MAX_PRIORITY_NUM]levelWaitStat //各级别的等待时间统计
 }
 
 func NewPriorityList(task *taskCategoryQueue) *priorityQueue {
 	p := &priorityQueue{}
 	i := 0
 	for _ = range p.lists {
-		p.lists[i] = list.New()
+		p.lists[i] = newFairList()
 		i++
 	}
 
 	p.quitChan = make(chan bool, 1)
+	p.notify = make(chan struct{}, 1)
 
 	p.taskQueues = task
+	p.taskQueues.spaceFreed = p.wakeup
+	p.capacity = readQueueCapacity("priority_capacity", defaultPriorityQueueCapacity)
+	p.aging = readAging()
 	return p
 }
 
+//通知分发协程，分发协程正在忙时信号已经存在，不需要重复发送
+func (l *priorityQueue) wakeup() {
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
+
+//从配置文件[priority]的aging_ms读取提升优先级的等待时间，0表示不提升
+func readAging() time.Duration {
+
+	defer utils.DealPanic()
+	ms, err := strconv.Atoi(utils.ConfigFile.Read_string("priority", "aging_ms", strconv.Itoa(defaultAgingMs)))
+	if err != nil || ms < 0 {
+		utils.Log.Error("priority aging_ms is not right, use %d", defaultAgingMs)
+		ms = defaultAgingMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
 func (l *priorityQueue) quit() {
 	l.quitChan <- true
 }
 
-//当直播任务完成后，会自动调用remove移除链表
-func (l *priorityQueue) listAdd(task *ReqSlbTask) {
+//当直播任务完成后，会自动调用remove移除链表。排队的请求已达上限时返回false
+func (l *priorityQueue) listAdd(task *ReqSlbTask) bool {
+	return l.listAddAt(task, time.Now())
+}
+
+func (l *priorityQueue) listAddAt(task *ReqSlbTask, now time.Time) bool {
 	l.lock.Lock()
 	defer l.lock.Unlock()
+
+	if l.queued >= l.capacity {
+		utils.Log.Error("priority queue is full, queued:%d", l.queued)
+		return false
+	}
+
+	task.origPriority = task.priority
+	task.enqueueTime = now
+	task.agingTime = now
 	priorityQueue := l.lists[task.priority]
 
 	utils.Log.Debug("task.priority:%d", task.priority)
-	priorityQueue.PushBack(task)
+	priorityQueue.push(task)
+	l.queued++
+
+	l.wakeup()
+	return true
 }
 
 func (l *priorityQueue) listRemove(e *list.Element) {
@@ -55,53 +118,133 @@ func (l *priorityQueue) listRemove(e *list.Element) {
 	priorityQueue := l.lists[task.priority]
 
 	priorityQueue.Remove(e)
+	l.queued--
 }
 
-//从优先级队列中取出任务，分发到任务属性队列(此优先级是否有必要，后续视实际情况)
+//从优先级队列中取出任务，分发到任务属性队列(此优先级是否有必要，后续视实际情况)。
+//有新任务或者任务属性队列有空位时才分发，不再定时轮询
 func (q *priorityQueue) run() {
-	//每次循环最多处理个数，防止此协程持续运行时间过久
-	const maxDealReqNum int = 1024
-	dealNum := 0
-
-	timeout := time.NewTicker(time.Millisecond * 10)
 
 	for {
 		select {
-		case <-timeout.C:
-			dealNum = 0
-			q.modifyPriority()
+		case <-q.notify:
+			//一次最多处理maxDealReqNum个，还有剩余时让出后继续分发
+			if q.dispatch(time.Now()) >= maxDealReqNum {
+				q.wakeup()
+			}
+		case <-q.quitChan:
+			return
+		}
+	}
+}
+
+//一次分发：先提升等待过久的任务的优先级，再从高到低取出任务放到任务属性队列，返回处理的任务数。
+//任务属性队列已满的任务留在优先级队列中，等有空位时再分发
+func (q *priorityQueue) dispatch(now time.Time) int {
+	dealNum := 0
 
-			listNum := len(q.lists)
+	q.lock.Lock()
+	defer q.lock.Unlock()
 
-			for listNum >= 1 && dealNum < maxDealReqNum {
+	q.modifyPriority(now)
 
-				singleList := q.lists[listNum-1]
+	full := make(map[string]bool)
+	for listNum := len(q.lists); listNum >= 1 && dealNum < maxDealReqNum; listNum-- {
 
-				for singleList.Len() > 0 && dealNum < maxDealReqNum {
+		singleList := q.lists[listNum-1]
 
-					dealNum++
-					e := singleList.Front()
-					if req, ok := e.Value.(*ReqSlbTask); ok {
-						utils.Log.Debug("doReq priorityQueue: check and move req form priorityQueue to taskQueue: Prioritylist has %d lists for different priority  , now is deal with Priority[%d] list. (now is dealing) ", len(q.lists), listNum)
-						utils.Log.Debug("doReq priorityQueue: the Priority[%d] list = has %d reqtasks  ", listNum, singleList.Len())
-						q.taskQueues.listAdd(req)
+		for e := singleList.Front(); e != nil && dealNum < maxDealReqNum; {
 
-						q.lock.Lock()
-						singleList.Remove(e)
-						q.lock.Unlock()
+			next := e.Next()
+			req := e.Value.(*ReqSlbTask)
+			//engine已经放弃等待的任务直接丢弃
+			if req.cancelled() {
+				singleList.Remove(e)
+				q.queued--
+				countTimeout(req, "priority queue")
+				e = next
+				continue
+			}
+			if full[req.TaskType] {
+				e = next
+				continue
+			}
 
+			dealNum++
+			utils.Log.Debug("doReq priorityQueue: move req form Priority[%d] list(%d reqtasks) to taskQueue", listNum-1, singleList.Len())
+			err := q.taskQueues.listAdd(req)
+			if err == errQueueFull {
+				full[req.TaskType] = true
+			} else {
+				//排队期间任务类型已经从配置中删除
+				if err != nil {
+					utils.Log.Error("doReq priorityQueue: drop req, type:%s err:%s", req.TaskType, err.Error())
+					//engine已经放弃等待时释放用户并发配额
+					if !req.deliver(&responseSlbReq{RetCode: retCodeNoTaskServer}) {
+						countTimeout(req, "priority queue")
 					}
 				}
+				singleList.take(e)
+				q.queued--
+				q.recordWait(req, now)
+			}
+			e = next
+		}
+	}
+
+	return dealNum
+}
+
+//优先级低的请求，如果长期不能被服务，调整其优先级别。
+//每等待aging时间提升一级，提升后在高一级的链表中当作刚到达的任务排队，
+//不排在已经排队的任务后面，这样最低级别的任务最多等待 9*aging 就会被分发。调用者需要持有q.lock
+func (l *priorityQueue) modifyPriority(now time.Time) {
 
-				listNum--
+	if l.aging <= 0 {
+		return
+	}
+
+	//从高到低处理，本轮提升的任务不会在同一轮被再次提升
+	for level := MAX_PRIORITY_NUM - 2; level >= 0; level-- {
+		for e := l.lists[level].Front(); e != nil; {
+			next := e.Next()
+			task := e.Value.(*ReqSlbTask)
+			//链表按用户公平排序，不是按进入时间排序，需要检查每个任务
+			if now.Sub(task.agingTime) < l.aging {
+				e = next
+				continue
 			}
-		case <-q.quitChan:
-			return
+
+			l.lists[level].Remove(e)
+			task.priority++
+			task.agingTime = now
+			l.lists[task.priority].pushAged(task)
+			utils.Log.Debug("task user:%s wait %v, priority %d -> %d", task.UserID, now.Sub(task.enqueueTime), level, task.priority)
+
+			e = next
 		}
 	}
 }
 
-//优先级低的请求，如果长期不能被服务，调整其优先级别
-func (l *priorityQueue) modifyPriority() {
+//统计任务在优先级队列中的等待时间，调用者需要持有q.lock
+func (l *priorityQueue) recordWait(task *ReqSlbTask, now time.Time) {
+	wait := now.Sub(task.enqueueTime)
+	stat := &l.waitStats[task.origPriority]
+	stat.count++
+	stat.total += wait
+	if wait > stat.maxWait {
+		stat.maxWait = wait
+	}
+}
+
+//某个优先级别的任务数、平均等待时间、最长等待时间
+func (l *priorityQueue) waitStat(level priorityLevel) (int64, time.Duration, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
 
+	stat := l.waitStats[level]
+	if stat.count == 0 {
+		return 0, 0, 0
+	}
+	return stat.count, stat.total / time.Duration(stat.count), stat.maxWait
 }
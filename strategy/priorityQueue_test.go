@@ -0,0 +1,122 @@
+package strategy
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//模拟持续的9级请求洪峰：每10毫秒到达1500个9级请求，超过每次最多分发的1024个，
+//同时每个低优先级别各有一个请求，返回各级别的等待统计
+func runPriorityFlood(t *testing.T, aging time.Duration, ticks int) *priorityQueue {
+
+	q := NewPriorityList(NewTaskCategoryList())
+	q.taskQueues.addCategory(CpuPro)
+	q.aging = aging
+	q.capacity = 1 << 20
+
+	base := time.Now()
+	for level := priorityLeve0; level < priorityLeve9; level++ {
+		q.listAddAt(&ReqSlbTask{TaskType: string(CpuPro), priority: level}, base)
+	}
+
+	for i := 0; i < ticks; i++ {
+		now := base.Add(time.Duration(i) * 10 * time.Millisecond)
+		for j := 0; j < 1500; j++ {
+			q.listAddAt(&ReqSlbTask{TaskType: string(CpuPro), priority: priorityLeve9}, now)
+		}
+		q.dispatch(now.Add(10 * time.Millisecond))
+		//任务属性队列由选机协程取走，这里直接清空
+		q.taskQueues.categories[CpuPro].tasks.Init()
+	}
+
+	for level := priorityLeve0; level <= priorityLeve9; level++ {
+		count, avg, max := q.waitStat(level)
+		t.Logf("aging %v level %d: dispatched %d, avg wait %v, max wait %v", aging, level, count, avg, max)
+	}
+	return q
+}
+
+func TestPriorityAgingBoundsStarvation(t *testing.T) {
+
+	aging := 100 * time.Millisecond
+	q := runPriorityFlood(t, aging, 200)
+
+	for level := priorityLeve0; level < priorityLeve9; level++ {
+		count, _, max := q.waitStat(level)
+		if count != 1 {
+			t.Fatalf("level %d task starved", level)
+		}
+		//最多提升(9-level)次，再加一个分发周期
+		bound := time.Duration(priorityLeve9-level)*aging + 10*time.Millisecond
+		if max > bound {
+			t.Errorf("level %d waited %v, want <= %v", level, max, bound)
+		}
+	}
+}
+
+func TestPriorityWithoutAgingStarves(t *testing.T) {
+
+	q := runPriorityFlood(t, 0, 200)
+
+	if count, _, _ := q.waitStat(priorityLeve0); count != 0 {
+		t.Errorf("level 0 task dispatched without aging under sustained level 9 traffic")
+	}
+}
+
+func TestPriorityDropCancelledTask(t *testing.T) {
+
+	q := NewPriorityList(NewTaskCategoryList())
+	q.taskQueues.addCategory(CpuPro)
+	q.capacity = 16
+
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := NewReqSlbTask()
+	abandoned.TaskType = string(CpuPro)
+	abandoned.SetContext(ctx)
+	kept := NewReqSlbTask()
+	kept.TaskType = string(CpuPro)
+	q.listAdd(abandoned)
+	q.listAdd(kept)
+
+	cancel()
+	before := atomic.LoadInt64(&stats.TimeoutDropped)
+	q.dispatch(time.Now())
+
+	if q.queued != 0 {
+		t.Fatalf("priority queue still holds %d tasks", q.queued)
+	}
+	l := q.taskQueues.categories[CpuPro].tasks
+	if l.Len() != 1 || l.Front().Value.(*ReqSlbTask) != kept {
+		t.Fatalf("cancelled task dispatched, task queue len %d", l.Len())
+	}
+	if n := atomic.LoadInt64(&stats.TimeoutDropped) - before; n != 1 {
+		t.Errorf("timeout dropped %d, want 1", n)
+	}
+
+	//放弃等待后结果不再送达
+	if res := abandoned.Wait(); res != nil {
+		t.Errorf("wait on cancelled task returned %+v", res)
+	}
+	if abandoned.deliver(&responseSlbReq{}) {
+		t.Error("deliver to abandoned task succeeded")
+	}
+}
+
+func TestRetiredTypeReleasesQuota(t *testing.T) {
+
+	q := NewPriorityList(NewTaskCategoryList())
+	quota := &userQuota{active: 1}
+	req := NewReqSlbTask()
+	req.TaskType = "FPGA"
+	req.hold = &quotaHold{quota: quota}
+	q.listAddAt(req, time.Now())
+
+	//任务类型已经删除，同时engine刚好放弃等待
+	req.abandoned = true
+	q.dispatch(time.Now())
+	if n := atomic.LoadInt64(&quota.active); n != 0 {
+		t.Errorf("abandoned request of retired type holds %d concurrency quota", n)
+	}
+}
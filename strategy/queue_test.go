@@ -0,0 +1,54 @@
+package strategy
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+//只实现选机接口，收到请求后立即回复，用于测量队列本身带来的延迟
+type benchStrategy struct {
+	StrategyInterface
+}
+
+func (b *benchStrategy) SelectServer(r *ReqSlbTask) {
+	*r.ResponseChan <- &responseSlbReq{RetCode: retCodeSuccess, IP: "127.0.0.1:80"}
+}
+
+func reportLatency(b *testing.B, latency []time.Duration) {
+	if len(latency) == 0 {
+		return
+	}
+	sort.Slice(latency, func(i, j int) bool { return latency[i] < latency[j] })
+	b.ReportMetric(float64(latency[len(latency)*50/100].Microseconds()), "p50-us")
+	b.ReportMetric(float64(latency[len(latency)*99/100].Microseconds()), "p99-us")
+}
+
+//请求从进入优先级队列到选机完成的延迟
+func BenchmarkSelectLatency(b *testing.B) {
+
+	tq := NewTaskCategoryList()
+	tq.addCategory(CpuPro)
+	tq.strategy = &benchStrategy{}
+	q := NewPriorityList(tq)
+	go q.run()
+	tq.run()
+	defer func() {
+		q.quit()
+		tq.quit()
+	}()
+
+	latency := make([]time.Duration, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		task := NewReqSlbTask()
+		task.TaskType = string(CpuPro)
+		task.ReqMode = SelectServer
+		start := time.Now()
+		q.listAdd(task)
+		<-*task.ResponseChan
+		latency = append(latency, time.Since(start))
+	}
+	b.StopTimer()
+	reportLatency(b, latency)
+}
@@ -0,0 +1,108 @@
+package strategy
+
+//用户配额：用户策略可以限制请求速率(令牌桶)和同时进行的任务数，
+//请求进入队列前检查，超过配额时回复429和Retry-After，避免一个用户占满整个转码集群。
+//同时进行的任务包括：排队中的请求、doWork模式正在代理的请求、selectServer模式未释放的租约
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+//超过并发配额时建议客户端等待的时长，单位秒
+const concurrencyRetryAfterSeconds int = 1
+
+//用户配额的运行状态
+type userQuota struct {
+	tokens float64   //令牌桶中剩余的令牌
+	last   time.Time //上次补充令牌的时间
+	active int64     //同时进行的任务数，任务结束时在其他协程中减少，使用原子操作
+}
+
+//任务占用的一个并发配额，租约会接管请求的配额，重复释放无影响
+type quotaHold struct {
+	quota    *userQuota
+	released int32
+}
+
+func (h *quotaHold) release() {
+	if h == nil {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&h.released, 0, 1) {
+		atomic.AddInt64(&h.quota.active, -1)
+	}
+}
+
+//令牌桶容量，没有配置时为1秒的请求数
+func (up *UserPolicy) burst() float64 {
+	if up.Burst > 0 {
+		return float64(up.Burst)
+	}
+	return math.Max(1, math.Ceil(up.Rate))
+}
+
+//按速率补充令牌后取一个令牌，令牌不足时返回需要等待的秒数
+func (q *userQuota) take(up *UserPolicy, now time.Time) (bool, int) {
+
+	if up.Rate <= 0 {
+		return true, 0
+	}
+
+	if q.last.IsZero() {
+		q.tokens = up.burst()
+	} else {
+		q.tokens = math.Min(up.burst(), q.tokens+now.Sub(q.last).Seconds()*up.Rate)
+	}
+	q.last = now
+
+	if q.tokens >= 1 {
+		q.tokens--
+		return true, 0
+	}
+	return false, int(math.Ceil((1 - q.tokens) / up.Rate))
+}
+
+//检查用户配额，通过时请求占用一个并发配额，超过配额时返回拒绝的回复。
+//解释选机时只检查，不消耗令牌和并发配额。只在Run协程中调用
+func (s *strategy) admitQuota(r *ReqSlbTask, up *UserPolicy, now time.Time) *responseSlbReq {
+
+	if up.Rate <= 0 && up.MaxConcurrent <= 0 {
+		r.trace("quota: no quota")
+		return nil
+	}
+
+	q, ok := s.quotas[userID(up.UserID)]
+	if !ok {
+		q = &userQuota{}
+		if !r.dryRun() {
+			s.quotas[userID(up.UserID)] = q
+		}
+	}
+
+	active := atomic.LoadInt64(&q.active)
+	if up.MaxConcurrent > 0 && active >= int64(up.MaxConcurrent) {
+		r.trace("quota: concurrent tasks %d reach MaxConcurrent %d", active, up.MaxConcurrent)
+		countRejected(r, "concurrency", &stats.ConcurrencyLimited)
+		return &responseSlbReq{RetCode: retCodeConcurrencyLimited, RetryAfter: concurrencyRetryAfterSeconds}
+	}
+
+	//解释选机时在副本上取令牌
+	bucket := q
+	if r.dryRun() {
+		bucket = &userQuota{tokens: q.tokens, last: q.last}
+	}
+	if pass, wait := bucket.take(up, now); !pass {
+		r.trace("quota: rate %v exceeded, retry after %ds", up.Rate, wait)
+		countRejected(r, "rate", &stats.RateLimited)
+		return &responseSlbReq{RetCode: retCodeRateLimited, RetryAfter: wait}
+	}
+
+	r.trace("quota: within quota, concurrent tasks %d", active)
+	if r.dryRun() {
+		return nil
+	}
+	atomic.AddInt64(&q.active, 1)
+	r.hold = &quotaHold{quota: q}
+	return nil
+}
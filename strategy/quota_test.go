@@ -0,0 +1,65 @@
+package strategy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaRateLimit(t *testing.T) {
+
+	s := &strategy{quotas: make(map[userID]*userQuota)}
+	up := &UserPolicy{UserID: "u1", Rate: 2, Burst: 3}
+	now := time.Now()
+
+	//令牌桶容量为3，之后每秒补充2个
+	for i := 0; i < 3; i++ {
+		if res := s.admitQuota(&ReqSlbTask{}, up, now); res != nil {
+			t.Fatalf("request %d rejected within burst", i)
+		}
+	}
+	res := s.admitQuota(&ReqSlbTask{}, up, now)
+	if res == nil || res.RetCode != retCodeRateLimited || res.RetryAfter != 1 || res.HttpStatus() != 429 {
+		t.Fatalf("over rate reply %+v", res)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if res := s.admitQuota(&ReqSlbTask{}, up, now); res != nil {
+		t.Errorf("request rejected after refill: %+v", res)
+	}
+}
+
+func TestQuotaMaxConcurrent(t *testing.T) {
+
+	s := &strategy{quotas: make(map[userID]*userQuota)}
+	up := &UserPolicy{UserID: "u1", MaxConcurrent: 2}
+	now := time.Now()
+
+	lease := &ReqSlbTask{ReqMode: SelectServer, lease: &lease{}}
+	work := &ReqSlbTask{ReqMode: DoWork}
+	for _, r := range []*ReqSlbTask{lease, work} {
+		if res := s.admitQuota(r, up, now); res != nil {
+			t.Fatalf("rejected within quota: %+v", res)
+		}
+	}
+	res := s.admitQuota(&ReqSlbTask{}, up, now)
+	if res == nil || res.RetCode != retCodeConcurrencyLimited || res.RetryAfter <= 0 {
+		t.Fatalf("over concurrency reply %+v", res)
+	}
+
+	//租约和doWork选机成功后仍然占用配额，结束后归还，重复归还无影响
+	chanRes := make(chan *responseSlbReq, 2)
+	lease.ResponseChan, work.ResponseChan = &chanRes, &chanRes
+	lease.deliver(&responseSlbReq{})
+	work.deliver(&responseSlbReq{})
+	if res := s.admitQuota(&ReqSlbTask{}, up, now); res == nil {
+		t.Fatal("quota released before task finished")
+	}
+	work.hold.release()
+	work.hold.release()
+	if res := s.admitQuota(&ReqSlbTask{}, up, now); res != nil {
+		t.Fatalf("quota not released: %+v", res)
+	}
+	if res := s.admitQuota(&ReqSlbTask{}, up, now); res == nil {
+		t.Fatal("double release returned two quotas")
+	}
+}
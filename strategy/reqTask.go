@@ -1,27 +1,100 @@
 package strategy
 
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
 //请求的模式
 type reqMode string
 
 const (
-	SelectServer = reqMode("selectServer")
-	DoWork       = reqMode("doWork")
+	SelectServer     = reqMode("selectServer")
+	DoWork           = reqMode("doWork")
+	SelectServerJson = reqMode("selectServerJson") //和selectServer相同，选机结果以JSON回复，不再302跳转
 )
 
 //定义返回错误码
 type retCode int
 
 const (
-	retCodeSuccess      = retCode(0) //成功
-	retCodeFail         = retCode(1)
-	retCodeNoTaskServer = retCode(2) //没有对应此任务类型的服务器
-	retCodeServerBusy   = retCode(3) //服务器忙
+	retCodeSuccess            = retCode(0) //成功
+	retCodeFail               = retCode(1)
+	retCodeNoTaskServer       = retCode(2) //没有对应此任务类型的服务器，配置中没有此Specialty的服务器
+	retCodeServerBusy         = retCode(3) //服务器忙
+	retCodeServerFull         = retCode(4) //所有服务器的转码槽位都已占满
+	retCodeQueueFull          = retCode(5) //排队的请求过多
+	retCodeRateLimited        = retCode(6) //超过用户的请求速率
+	retCodeConcurrencyLimited = retCode(7) //超过用户同时进行的任务数
+	retCodeTimeout            = retCode(8) //等待选机结果超时
 )
 
+//错误码的描述，回复JSON时填写到Reason
+var retCodeReasons = map[retCode]string{
+	retCodeSuccess:            "success",
+	retCodeFail:               "no available server",
+	retCodeNoTaskServer:       "no server for this task type",
+	retCodeServerBusy:         "all servers are busy",
+	retCodeServerFull:         "all transcoding slots are in use",
+	retCodeQueueFull:          "too many requests are queued",
+	retCodeRateLimited:        "user request rate exceeded",
+	retCodeConcurrencyLimited: "user concurrent tasks exceeded",
+	retCodeTimeout:            "timeout waiting for a server",
+}
+
 //针对ReqSlbTask的回复
 type responseSlbReq struct {
-	RetCode retCode //成功此值是0，失败非0
-	IP      string  //成功返回正确IP地址，失败此值无意义
+	RetCode    retCode //成功此值是0，失败非0
+	IP         string  //成功返回正确的IP:端口，失败此值无意义
+	Lease      string  //申请了租约时返回租约ID
+	TTL        int     //租约时长，单位秒
+	Pool       string  //选中服务器所在的调度池
+	Fallback   bool    //任务类型的调度池没有正常服务器，降级到了其他调度池
+	RetryAfter int     //超过用户配额时，建议客户端等待的秒数
+	Host       string  //选中服务器的地址
+	Port       int     //选中服务器的端口
+	Reason     string  //结果描述
+}
+
+//等待选机结果超时的回复
+func TimeoutResponse() *responseSlbReq {
+	return &responseSlbReq{RetCode: retCodeTimeout}
+}
+
+//回复JSON之前填写服务器地址、端口和结果描述
+func (r *responseSlbReq) Describe() {
+	if host, port, err := net.SplitHostPort(r.IP); err == nil {
+		r.Host = host
+		r.Port, _ = strconv.Atoi(port)
+	}
+	if r.Reason == "" {
+		r.Reason = retCodeReasons[r.RetCode]
+	}
+}
+
+func (r *responseSlbReq) Success() bool {
+	return r.RetCode == retCodeSuccess
+}
+
+//失败时回复给客户端的http状态码
+func (r *responseSlbReq) HttpStatus() int {
+	switch r.RetCode {
+	case retCodeSuccess:
+		return http.StatusOK
+	case retCodeServerBusy, retCodeServerFull, retCodeQueueFull:
+		return http.StatusServiceUnavailable
+	case retCodeNoTaskServer:
+		return http.StatusBadRequest
+	case retCodeRateLimited, retCodeConcurrencyLimited:
+		return http.StatusTooManyRequests
+	case retCodeTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
 }
 
 //针对ReqPolicyTask的回复
@@ -58,17 +131,93 @@ const (
 
 //请求SLB服务器时，客户端带的body参数
 type ReqSlbTask struct {
-	UserID       string //用户ID，如果非特殊用户可以不设置此值。
-	SessonID     string //会话ID
-	TaskType     string        //任务类型
-	priority     priorityLevel //用户优先级,提前被设置的
-	ReqMode      reqMode       //请求模式，1 ”selectServer“ slb返回选择的机器 2 ”doWork“ slb直接帮助处理此请求;默认为1
-	ResponseChan *chan string  //针对每个请求的同步回复通道
+	UserID         string                //用户ID，如果非特殊用户可以不设置此值。
+	SessonID       string                //会话ID
+	TaskType       string                //任务类型
+	priority       priorityLevel         //用户优先级,提前被设置的
+	ReqMode        reqMode               //请求模式，1 ”selectServer“ slb返回选择的机器 2 ”doWork“ slb直接帮助处理此请求;默认为1
+	Codec          codecType             //编码类型，H264/H265，为空时不占用转码槽位
+	Lease          bool                  //selectServer模式下是否申请租约
+	LeaseTTL       int                   //申请的租约时长，单位秒，为0时使用配置的默认时长
+	ResponseChan   *chan *responseSlbReq //针对每个请求的同步回复通道
+	target         *server               //选中的服务器
+	slot           *taskSlot             //占用的转码槽位
+	lease          *lease                //申请的租约
+	fallback       bool                  //是否降级到了其他调度池
+	fallbackFactor float64               //正在按降级链选机时为降级系数，其余时候为0
+	triedIPs       []string              //doWork模式已经代理失败的服务器
+	pinned         []serverIP            //用户策略指定的服务器，重试时按相同的顺序选择
+	pinnedFallback pinnedFallback        //指定的服务器都不可用时的处理
+	retries        int                   //doWork模式已经重试的次数
+	hold           *quotaHold            //占用的用户并发配额
+	explain        *responseExplainReq   //解释选机时记录决定过程，不为空时只预测不占用资源
+	origPriority   priorityLevel         //进入队列时的优先级，等待过久时priority会被提升
+	weight         int                   //同一优先级内按用户公平排队的权重，来自用户策略
+	fairTag        float64               //公平排队的虚拟完成时间
+	createTime     time.Time             //请求创建的时间
+	enqueueTime    time.Time             //进入优先级队列的时间
+	agingTime      time.Time             //上次提升优先级的时间
+	ctx            context.Context       //请求的上下文，engine超时或者客户端断开时取消
+	mu             sync.Mutex            //保护abandoned和回复通道
+	abandoned      bool                  //engine已经放弃等待此请求的回复
 }
 
 func NewReqSlbTask() *ReqSlbTask {
-	r := &ReqSlbTask{}
-	chanStr := make(chan string, 1)
-	r.ResponseChan = &chanStr
+	r := &ReqSlbTask{createTime: time.Now(), ctx: context.Background()}
+	chanRes := make(chan *responseSlbReq, 1)
+	r.ResponseChan = &chanRes
 	return r
 }
+
+//设置请求的上下文，上下文取消后请求会从队列中丢弃，不再占用服务器
+func (r *ReqSlbTask) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+func (r *ReqSlbTask) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+//engine是否已经不再需要此请求的结果
+func (r *ReqSlbTask) cancelled() bool {
+	return r.context().Err() != nil
+}
+
+//等待选机结果，上下文取消时放弃此请求并返回nil。
+//放弃的同时如果结果已经送达，仍然返回结果，避免选中的服务器被白白占用
+func (r *ReqSlbTask) Wait() *responseSlbReq {
+	select {
+	case res := <-*r.ResponseChan:
+		return res
+	case <-r.context().Done():
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.abandoned = true
+	select {
+	case res := <-*r.ResponseChan:
+		return res
+	default:
+		return nil
+	}
+}
+
+//把选机结果交给engine，engine已经放弃等待时返回false
+func (r *ReqSlbTask) deliver(response *responseSlbReq) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.abandoned {
+		return false
+	}
+
+	*r.ResponseChan <- response
+	//选机结束后不再占用并发配额的请求：选机失败的请求，和没有申请租约的selectServer请求
+	if !response.Success() || (r.ReqMode != DoWork && r.lease == nil) {
+		r.hold.release()
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package strategy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDescribeResponse(t *testing.T) {
+
+	res := &responseSlbReq{RetCode: retCodeSuccess, IP: "192.168.1.1:8080", Pool: "CPU", Lease: "l1", TTL: 30}
+	res.Describe()
+	if res.Host != "192.168.1.1" || res.Port != 8080 || res.Reason != "success" {
+		t.Fatalf("described success %+v", res)
+	}
+
+	buf, _ := json.Marshal(res)
+	reply := map[string]interface{}{}
+	json.Unmarshal(buf, &reply)
+	for _, key := range []string{"Host", "Port", "Pool", "Lease", "RetCode", "Reason"} {
+		if _, ok := reply[key]; !ok {
+			t.Errorf("json reply has no %s: %s", key, string(buf))
+		}
+	}
+
+	//失败时没有服务器地址，每个错误码都有描述
+	for code := retCodeSuccess; code <= retCodeTimeout; code++ {
+		res := &responseSlbReq{RetCode: code}
+		res.Describe()
+		if res.Reason == "" || res.Host != "" {
+			t.Errorf("retCode %d described as %+v", code, res)
+		}
+	}
+	if res := TimeoutResponse(); res.HttpStatus() != 504 {
+		t.Errorf("timeout http status %d", res.HttpStatus())
+	}
+
+	//服务器暂时不能分配时客户端可以稍后重试
+	for _, code := range []retCode{retCodeServerBusy, retCodeServerFull, retCodeQueueFull} {
+		if status := (&responseSlbReq{RetCode: code}).HttpStatus(); status != 503 {
+			t.Errorf("retCode %d http status %d", code, status)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package strategy
+
+//doWork模式的重试：代理连接后端失败时换一台服务器重试，不会重试同一台服务器。
+//重试受三个限制，配置在[retry]：
+//	max_retries     每个请求最多重试的次数
+//	budget_percent  重试预算，重试次数不超过doWork请求数的百分比，避免后端大面积故障时重试把流量放大
+//	max_body_bytes  请求body的最大字节数，body要完整解析参数、代理时原样转发和重试时重放，超过时拒绝请求
+import (
+	"bytes"
+	"common/utils"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxRetries         int = 2
+	defaultRetryBudgetPercent int = 20
+	defaultRetryMaxBodyBytes  int = 1 << 20
+)
+
+//重试预算最多累积的次数，空闲后遇到突发故障时也可以重试
+const retryBudgetBurst float64 = 10
+
+type retryConfig struct {
+	maxRetries  int     //每个请求最多重试的次数，0表示不重试
+	budgetRatio float64 //每个doWork请求增加的重试预算
+}
+
+//重试预算：每个doWork请求增加budgetRatio次，每次重试消耗1次
+type retryBudget struct {
+	ratio  float64
+	tokens float64
+}
+
+func readRetryInt(key string, def int) int {
+	v, err := strconv.Atoi(utils.ConfigFile.Read_string("retry", key, strconv.Itoa(def)))
+	if err != nil || v < 0 {
+		utils.Log.Error("retry %s is not right, use %d", key, def)
+		v = def
+	}
+	return v
+}
+
+//从配置文件[retry]读取
+func readRetryConfig() retryConfig {
+
+	defer utils.DealPanic()
+	c := retryConfig{}
+	c.maxRetries = readRetryInt("max_retries", defaultMaxRetries)
+	c.budgetRatio = float64(readRetryInt("budget_percent", defaultRetryBudgetPercent)) / 100
+	return c
+}
+
+//请求body最大字节数，从配置文件[retry]的max_body_bytes读取，必须大于0
+func ReadMaxBodyBytes() int {
+	defer utils.DealPanic()
+	v := readRetryInt("max_body_bytes", defaultRetryMaxBodyBytes)
+	if v <= 0 {
+		utils.Log.Error("retry max_body_bytes is not right, use %d", defaultRetryMaxBodyBytes)
+		v = defaultRetryMaxBodyBytes
+	}
+	return v
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio, tokens: retryBudgetBurst}
+}
+
+func (b *retryBudget) deposit() {
+	b.tokens += b.ratio
+	if b.tokens > retryBudgetBurst {
+		b.tokens = retryBudgetBurst
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+//缓存请求body用于解析和重放，最多读取limit+1字节，不会把大body整个读进内存。
+//不超过limit时r.Body换成缓存，返回ok为true；超过时返回false，调用者应当拒绝此请求
+func BufferBody(r *http.Request, limit int) (buf []byte, ok bool) {
+	buf, _ = ioutil.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	r.Body.Close()
+	if len(buf) > limit {
+		return nil, false
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	return buf, true
+}
+
+//此服务器是否已经尝试过，重试时不再选择同一台服务器
+func (r *ReqSlbTask) tried(s *server) bool {
+	for _, v := range r.triedIPs {
+		if v == s.ip {
+			return true
+		}
+	}
+	return false
+}
+
+//代理连接上一台服务器失败，按第一次选机相同的策略换一台服务器。
+//上一台服务器的失败先反馈给被动健康检查；不能重试或者没有其他服务器时返回nil
+func (s *strategy) RetryWork(r *ReqSlbTask) *responseSlbReq {
+	defer utils.DealPanic()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	failed := r.target
+	if failed == nil {
+		return nil
+	}
+	s.finishAttempt(r, false)
+	r.triedIPs = append(r.triedIPs, failed.ip)
+
+	if r.retries >= s.retry.maxRetries {
+		utils.Log.Info("doWork to server:%s failed, retried %d times, give up", failed.ip, r.retries)
+		r.hold.release()
+		return nil
+	}
+	if !s.retryBudget.withdraw() {
+		n := atomic.AddInt64(&stats.RetryBudgetExhausted, 1)
+		utils.Log.Error("doWork to server:%s failed, retry budget exhausted, total:%d", failed.ip, n)
+		r.hold.release()
+		return nil
+	}
+	r.retries++
+
+	ser, code, err := s.retryServer(r)
+	if err != nil {
+		utils.Log.Info("doWork to server:%s failed, no other server to retry: %s", failed.ip, err.Error())
+		r.hold.release()
+		return &responseSlbReq{RetCode: code}
+	}
+
+	response := &responseSlbReq{RetCode: retCodeSuccess}
+	s.occupy(r, ser, response)
+	response.IP = ser.ip + ":" + strconv.Itoa(ser.port)
+	n := atomic.AddInt64(&stats.Retries, 1)
+	utils.Log.Info("doWork to server:%s failed, retry %d on server:%s, retries total:%d", failed.ip, r.retries, response.IP, n)
+	return response
+}
+
+//结束一次doWork代理：释放占用的资源，结果反馈给被动健康检查。调用者需要持有s.lock
+func (s *strategy) finishAttempt(r *ReqSlbTask, ok bool) {
+	target := r.target
+	if target == nil {
+		return
+	}
+
+	s.releaseOccupied(r)
+	s.observe(target, ok, time.Now())
+}
@@ -0,0 +1,190 @@
+package strategy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryWorkOtherServer(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU", "CPU")
+	s.retry = retryConfig{maxRetries: 2, budgetRatio: 1}
+	s.retryBudget = newRetryBudget(1)
+	s.updateBalancers()
+
+	r := &ReqSlbTask{TaskType: string(CpuPro), ReqMode: DoWork}
+	ser, _, _ := s.getBackendServer(r)
+	s.occupy(r, ser, &responseSlbReq{})
+
+	seen := map[string]bool{ser.ip: true}
+	for i := 0; i < 2; i++ {
+		res := s.RetryWork(r)
+		if res == nil || !res.Success() {
+			t.Fatalf("retry %d failed", i+1)
+		}
+		if seen[r.target.ip] {
+			t.Fatalf("retry %d on server %s again", i+1, r.target.ip)
+		}
+		seen[r.target.ip] = true
+	}
+
+	//超过重试次数
+	if res := s.RetryWork(r); res != nil {
+		t.Errorf("retry beyond max_retries: %+v", res)
+	}
+	for _, v := range s.servers {
+		if v.inflight != 0 {
+			t.Errorf("server %s inflight %d after retries", v.ip, v.inflight)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+
+	b := newRetryBudget(0.2)
+	for i := 0; i < int(retryBudgetBurst); i++ {
+		if !b.withdraw() {
+			t.Fatalf("withdraw %d failed within burst", i)
+		}
+	}
+	if b.withdraw() {
+		t.Fatal("withdraw succeeded with empty budget")
+	}
+
+	//每5个请求才能重试1次
+	for i := 0; i < 5; i++ {
+		b.deposit()
+	}
+	if !b.withdraw() || b.withdraw() {
+		t.Error("budget does not follow budget_percent")
+	}
+}
+
+func TestDoWorkRetryOnConnectFailure(t *testing.T) {
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	addr := strings.TrimPrefix(backend.URL, "http://")
+	backend.Close()
+
+	//可以重试时不回复502，由调用者重试
+	req := httptest.NewRequest(http.MethodPost, "/yfy/select/lb/server", strings.NewReader("{}"))
+	w := httptest.NewRecorder()
+	ok, retry := DoWorkForCustomer(w, req, addr, true)
+	if ok || !retry || w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("retryable failure: ok %v retry %v code %d", ok, retry, w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/yfy/select/lb/server", strings.NewReader("{}"))
+	w = httptest.NewRecorder()
+	ok, retry = DoWorkForCustomer(w, req, addr, false)
+	if ok || retry || w.Code != http.StatusBadGateway {
+		t.Errorf("not retryable failure: ok %v retry %v code %d", ok, retry, w.Code)
+	}
+}
+
+func TestProxyWorkReleasesOnAbort(t *testing.T) {
+
+	//后端持续输出，直到客户端断开
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for r.Context().Err() == nil {
+			w.Write(make([]byte, 1024))
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	s := newCategoryTestStrategy("CPU")
+	ser := s.servers["192.168.1.1"]
+	ser.H264Capacity = 1
+	q := &userQuota{active: 1}
+	r := &ReqSlbTask{TaskType: string(CpuPro), ReqMode: DoWork, Codec: H264, hold: &quotaHold{quota: q}}
+	s.lock.Lock()
+	s.occupy(r, ser, &responseSlbReq{})
+	s.lock.Unlock()
+
+	done := make(chan bool)
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer close(done)
+		s.ProxyWork(w, req, r, strings.TrimPrefix(backend.URL, "http://"))
+	}))
+	defer front.Close()
+
+	//客户端读到一部分数据后断开，ReverseProxy中途panic
+	res, err := http.Get(front.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadFull(res.Body, make([]byte, 4096))
+	res.Body.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not stop after client disconnected")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if ser.inflight != 0 || len(ser.H264TaskSlice) != 0 || atomic.LoadInt64(&q.active) != 0 || r.target != nil {
+		t.Errorf("aborted proxy leaked inflight %d slots %d quota %d", ser.inflight, len(ser.H264TaskSlice), q.active)
+	}
+	//客户端断开不计为服务器失败
+	if ser.consecutiveFails != 0 {
+		t.Errorf("client abort counted as server failure")
+	}
+}
+
+func TestBufferBodyLimit(t *testing.T) {
+
+	req := httptest.NewRequest(http.MethodPost, "/yfy/select/lb/server", strings.NewReader("0123456789"))
+	buf, ok := BufferBody(req, 10)
+	if !ok || string(buf) != "0123456789" {
+		t.Fatalf("small body buffered %q ok %v", buf, ok)
+	}
+	if read, _ := io.ReadAll(req.Body); string(read) != "0123456789" {
+		t.Errorf("small body read back %q", read)
+	}
+
+	//超过max_body_bytes时拒绝，不会只用前面的部分解析参数
+	req = httptest.NewRequest(http.MethodPost, "/yfy/select/lb/server", strings.NewReader(strings.Repeat("x", 100)))
+	if buf, ok = BufferBody(req, 10); ok || buf != nil {
+		t.Fatalf("large body buffered %d bytes ok %v", len(buf), ok)
+	}
+}
+
+func TestRetryWorkPinned(t *testing.T) {
+
+	for _, fallback := range []pinnedFallback{PinnedFallbackFail, PinnedFallbackSchedule} {
+		s := newCategoryTestStrategy("CPU", "CPU", "CPU")
+		s.retry = retryConfig{maxRetries: 3, budgetRatio: 1}
+		s.retryBudget = newRetryBudget(1)
+		s.updateBalancers()
+		up := &UserPolicy{UserID: "u1", Ips: []serverIP{"192.168.1.3", "192.168.1.2"}, Fallback: fallback}
+
+		r, chanRes := pinnedReq()
+		s.dealPinned(r, up)
+		if res := <-chanRes; res.IP != "192.168.1.3:80" {
+			t.Fatalf("%s first pick %+v", fallback, res)
+		}
+
+		//重试先使用固定服务器列表中没有试过的服务器
+		if res := s.RetryWork(r); res == nil || res.IP != "192.168.1.2:80" {
+			t.Fatalf("%s retry on pinned server %+v", fallback, res)
+		}
+
+		//固定服务器都试过后，fail不再重试，schedule到调度池选机
+		res := s.RetryWork(r)
+		if fallback == PinnedFallbackFail {
+			if res == nil || res.Success() || r.target != nil {
+				t.Errorf("fail policy retried outside pinned servers %+v", res)
+			}
+		} else if res == nil || res.IP != "192.168.1.1:80" {
+			t.Errorf("schedule policy retry %+v", res)
+		}
+	}
+}
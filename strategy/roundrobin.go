@@ -3,115 +3,111 @@ package strategy
 import (
 	"common/utils"
 	"errors"
+	"time"
 )
 
+//平滑加权轮询(同nginx)：每次选择时所有可用服务器的当前权重加上自身权重，
+//选出当前权重最大的一台，再减去本轮可用服务器的权重总和。
+//权重为5,1,1时选择顺序为a a b a c a a，不会把请求连续集中到权重最大的服务器上
 type roundRobin struct {
-	lastServerIdx int       //上次获取到的服务器索引
-	servers       []*server //服务器列表
-	currentWeight int       //当前权重
+	servers       []*server       //服务器列表
+	currentWeight map[*server]int //每台服务器的当前权重
+}
+
+func init() {
+	registerBalancer(RoundRobin, func(tp taskProperty) Balancer { return NewRoundRobin(nil) })
 }
 
 //输入的对象指针
 func NewRoundRobin(inputServers []*server) *roundRobin {
 
 	defer utils.DealPanic()
-	return &roundRobin{servers: inputServers, lastServerIdx: -1}
+	rr := &roundRobin{currentWeight: make(map[*server]int)}
+	rr.Rebuild(inputServers)
+	return rr
 }
 
-//获取后端服务器中最大的权重值
-func (rr *roundRobin) getMaxWeight() int {
+//一轮平滑加权选择，weightOf返回服务器本轮的有效权重，小于等于0的服务器不参与选择。
+//只遍历一遍服务器列表，服务器状态变化时也不会循环
+func smoothPick(r *ReqSlbTask, servers []*server, currentWeight map[*server]int, weightOf func(*server) int) *server {
 
-	defer utils.DealPanic()
-	if len(rr.servers) == 0 {
-		return 0
-	}
-
-	maxWeight := 0
-	for _, s := range rr.servers {
-		//当服务区down掉，或者CPU标高，都暂时跳过此服务器
-		if !checkSeverState(s) {
+	var best *server
+	total := 0
+	for _, s := range servers {
+		//当服务区down掉，或者CPU标高，或者没有空闲转码槽位，都暂时跳过此服务器
+		if !serverAvailable(s, r) {
 			continue
 		}
 
-		if s.weight > maxWeight {
-			maxWeight = s.weight
+		w := weightOf(s)
+		if w <= 0 {
+			continue
 		}
-	}
 
-	return maxWeight
-}
-
-//辗转相除法：最大公约数
-func gcdx(x, y int) int {
-
-	defer utils.DealPanic()
-	var tmp int
-	for {
-		tmp = (x % y)
-		if tmp > 0 {
-			x = y
-			y = tmp
-		} else {
-			return y
+		currentWeight[s] += w
+		total += w
+		if best == nil || currentWeight[s] > currentWeight[best] {
+			best = s
 		}
 	}
-}
-
-func (rr *roundRobin) getMaxGcd() int {
-
-	defer utils.DealPanic()
-	maxGcd := maxWeight
 
-	for _, s := range rr.servers {
-		if !checkSeverState(s) {
-			continue
-		}
-		maxGcd = gcdx(maxGcd, s.weight)
+	if best != nil {
+		currentWeight[best] -= total
 	}
-
-	return maxGcd
+	return best
 }
 
 //获取后端服务器
-func (rr *roundRobin) getBackendServer() (*server, error) {
+func (rr *roundRobin) getBackendServer(r *ReqSlbTask) (*server, error) {
 
 	defer utils.DealPanic()
-	if len(rr.servers) == 0 {
+	now := time.Now()
+	best := smoothPick(r, rr.servers, rr.currentWeight, func(s *server) int { return s.rampedWeight(now) })
+	if best == nil {
 		utils.Log.Debug(" no server")
 		return nil, errors.New("no server")
 	}
 
-	for {
-		rr.lastServerIdx = (rr.lastServerIdx + 1) % len(rr.servers)
-		utils.Log.Debug("getBackendServer ServerIdx indx:=%d,len(rr.servers)=%d ", rr.lastServerIdx, len(rr.servers))
-		if rr.lastServerIdx == 0 {
-			rr.currentWeight = rr.currentWeight - rr.getMaxGcd()
-			utils.Log.Debug("getBackendServer rr.getMaxGcd():%d,%d", rr.getMaxGcd(), rr.currentWeight)
-			if rr.currentWeight <= 0 {
-				rr.currentWeight = rr.getMaxWeight()
-				utils.Log.Debug("max weight:%d", rr.currentWeight)
-				if rr.currentWeight == 0 {
-					return nil, errors.New("no server")
-				}
-			}
-		}
+	utils.Log.Debug("getBackendServer ip:%s, w:%d, currentWeight:%d", best.ip, best.weight, rr.currentWeight[best])
+	return best, nil
+}
 
-		//当服务区down掉，或者CPU标高，都暂时跳过此服务器
-		if !checkSeverState(rr.servers[rr.lastServerIdx]) {
-			utils.Log.Debug("server %v state fail", rr.servers[rr.lastServerIdx].state.Down)
-			continue
-		}
+func (rr *roundRobin) Pick(r *ReqSlbTask) (*server, error) {
+	return rr.getBackendServer(r)
+}
 
-		utils.Log.Debug("w:%d ,currentWeight:%d", rr.servers[rr.lastServerIdx].weight, rr.currentWeight)
-		if rr.servers[rr.lastServerIdx].weight >= rr.currentWeight {
-			return rr.servers[rr.lastServerIdx], nil
+//保留仍然可用的服务器的当前权重，使重建前后的选择顺序保持平滑；
+//被移除或者不可用的服务器清零，恢复后从头参与选择
+func (rr *roundRobin) Rebuild(servers []*server) {
+	currentWeight := make(map[*server]int)
+	for _, s := range servers {
+		if w, ok := rr.currentWeight[s]; ok && checkSeverState(s) {
+			currentWeight[s] = w
 		}
 	}
+	rr.servers = servers
+	rr.currentWeight = currentWeight
+}
+
+//在当前权重的副本上选择
+func (rr *roundRobin) Peek(r *ReqSlbTask) (*server, error) {
+	peek := *rr
+	peek.currentWeight = copyWeights(rr.currentWeight)
+	return peek.getBackendServer(r)
+}
+
+func copyWeights(currentWeight map[*server]int) map[*server]int {
+	m := make(map[*server]int, len(currentWeight))
+	for k, v := range currentWeight {
+		m[k] = v
+	}
+	return m
 }
 
 func checkSeverState(s *server) bool {
 	defer utils.DealPanic()
-	if s.state.Down == true || s.state.CupUtil > 90 {
+	//负载超过阈值、被动健康检查摘除期间、排空和维护时同样不可用
+	if s.state.Down == true || s.overloaded || s.ejected(time.Now()) || !s.accepting() {
 		return false
 	}
 
@@ -0,0 +1,131 @@
+package strategy
+
+import (
+	"strconv"
+	"testing"
+)
+
+func newTestServers(weights ...int) []*server {
+	var servers []*server
+	for i, w := range weights {
+		servers = append(servers, &server{
+			ip:     "192.168.1." + strconv.Itoa(i+1),
+			port:   80,
+			weight: w,
+			state:  &ServerState{},
+			limits: defaultThresholds,
+		})
+	}
+	return servers
+}
+
+func TestRoundRobinSmoothOrder(t *testing.T) {
+
+	servers := newTestServers(5, 1, 1)
+	rr := NewRoundRobin(servers)
+
+	//nginx平滑加权轮询的固定顺序
+	want := []int{0, 0, 1, 0, 2, 0, 0}
+	for round := 0; round < 3; round++ {
+		for i, idx := range want {
+			s, err := rr.getBackendServer(&ReqSlbTask{})
+			if err != nil {
+				t.Fatalf("round %d pick %d: %s", round, i, err.Error())
+			}
+			if s != servers[idx] {
+				t.Fatalf("round %d pick %d: got %s, want %s", round, i, s.ip, servers[idx].ip)
+			}
+		}
+	}
+}
+
+func TestRoundRobinFairness(t *testing.T) {
+
+	servers := newTestServers(3, 2, 1, 4)
+	rr := NewRoundRobin(servers)
+
+	count := make(map[*server]int)
+	last, run, maxRun := (*server)(nil), 0, 0
+	for i := 0; i < 1000; i++ {
+		s, err := rr.getBackendServer(&ReqSlbTask{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		count[s]++
+		if s == last {
+			run++
+		} else {
+			last, run = s, 1
+		}
+		if run > maxRun {
+			maxRun = run
+		}
+
+		//每10次选择是一个完整周期，周期内每台服务器的次数等于权重
+		if (i+1)%10 == 0 {
+			for _, v := range servers {
+				if count[v] != v.weight*(i+1)/10 {
+					t.Fatalf("after %d picks server %s picked %d times, want %d", i+1, v.ip, count[v], v.weight*(i+1)/10)
+				}
+			}
+		}
+	}
+
+	//总权重为10，1000次选择后每台服务器的次数严格等于权重*100
+	for _, s := range servers {
+		if count[s] != s.weight*100 {
+			t.Errorf("server %s weight %d picked %d times, want %d", s.ip, s.weight, count[s], s.weight*100)
+		}
+	}
+
+	//权重最大的服务器不会被成批连续选中
+	if maxRun > 2 {
+		t.Errorf("same server picked %d times in a row", maxRun)
+	}
+}
+
+func TestRoundRobinSkipDownServer(t *testing.T) {
+
+	servers := newTestServers(2, 1, 1)
+	rr := NewRoundRobin(servers)
+	servers[0].state.Down = true
+	servers[2].updateOverload(&ServerState{CupUtil: 95})
+
+	for i := 0; i < 10; i++ {
+		s, err := rr.getBackendServer(&ReqSlbTask{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != servers[1] {
+			t.Fatalf("picked unhealthy server %s", s.ip)
+		}
+	}
+
+	//恢复后按权重重新分配
+	servers[0].state.Down = false
+	servers[2].updateOverload(&ServerState{})
+	rr.Rebuild(servers)
+	count := make(map[*server]int)
+	for i := 0; i < 400; i++ {
+		s, _ := rr.getBackendServer(&ReqSlbTask{})
+		count[s]++
+	}
+	if count[servers[0]] != 200 || count[servers[1]] != 100 || count[servers[2]] != 100 {
+		t.Errorf("unexpected distribution after recover: %d %d %d", count[servers[0]], count[servers[1]], count[servers[2]])
+	}
+}
+
+func TestRoundRobinNoServer(t *testing.T) {
+
+	rr := NewRoundRobin(nil)
+	if _, err := rr.getBackendServer(&ReqSlbTask{}); err == nil {
+		t.Error("expect error with empty server list")
+	}
+
+	servers := newTestServers(1, 0)
+	servers[0].state.Down = true
+	rr.Rebuild(servers)
+	if _, err := rr.getBackendServer(&ReqSlbTask{}); err == nil {
+		t.Error("expect error when no server available")
+	}
+}
@@ -5,14 +5,16 @@ package strategy
 import (
 	"common/utils"
 	"encoding/json"
+	"errors"
 
 	"fmt"
-	"regexp"
+	"net/http"
 
 	"slb/config"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type StrategyInterface interface {
@@ -35,11 +37,35 @@ type StrategyInterface interface {
 	//更新服务的状态
 	UpdateServerState(ss *ServerState)
 
+	//续约或者释放租约
+	UpdateLease(req *ReqLeaseTask)
+
 	//选择服务器
 	SelectServer(r *ReqSlbTask)
 
 	//代替客户处理内容
 	DoWork(r *ReqSlbTask)
+
+	//代替客户处理内容结束，反馈处理结果
+	FinishWork(r *ReqSlbTask, ok bool)
+
+	//代理一次doWork请求并在结束时释放资源，返回是否需要换一台服务器重试
+	ProxyWork(w http.ResponseWriter, req *http.Request, r *ReqSlbTask, strIP string) bool
+
+	//代替客户处理内容时连接服务器失败，换一台服务器
+	RetryWork(r *ReqSlbTask) *responseSlbReq
+
+	//查询或者设置服务器管理状态
+	UpdateAdmin(req *ReqAdminTask)
+
+	//解释选机过程，不占用服务器
+	ExplainSlbReq(req *ReqExplainTask)
+
+	//查询服务器和调度池的只读快照
+	Snapshot(req *ReqSnapshotTask)
+
+	//查询或者删除用户策略
+	ManageUserPolicy(req *ReqPolicyTask)
 }
 
 //服务器状态，从健康检查机制获取
@@ -58,49 +84,87 @@ type ServerState struct {
 const maxWeight int = 100
 
 type server struct {
-	ip            string       //服务器IP
-	port          int          //服务器工作端口
-	weight        int          //服务器权重
-	state         *ServerState //服务器状态
-	specialty     taskProperty //服务器功能特长
-	H264TaskSlice []int        //H264任务数组
-	H265TaskSlice []int        //H265任务数组
-	H264Capacity  int          //H264能力
-	H265Capacity  int          //H265能力
+	ip               string        //服务器IP
+	port             int           //服务器工作端口
+	weight           int           //服务器权重
+	state            *ServerState  //服务器状态
+	specialty        taskProperty  //服务器功能特长
+	H264TaskSlice    []*taskSlot   //H264任务占用的槽位
+	H265TaskSlice    []*taskSlot   //H265任务占用的槽位
+	fallbackTasks    []*taskSlot   //没有编码类型的降级请求占用的任务，按权重和降级系数限制
+	H264Capacity     int           //H264能力，可同时处理的任务数
+	H265Capacity     int           //H265能力，可同时处理的任务数
+	inflight         int           //正在处理的任务数：doWork模式下正在代理的请求和未释放的租约
+	slowStart        time.Duration //从宕机恢复后的慢启动时长
+	upSince          time.Time     //最近一次从宕机恢复的时间
+	seenUp           bool          //加入后是否已经正常过
+	consecutiveFails int           //doWork代理连续失败的次数
+	ejectCount       int           //被动健康检查连续摘除的次数，决定下次摘除时长
+	ejectUntil       time.Time     //摘除到期时间
+	restored         bool          //摘除到期后是否已经重新加入调度池
+	limits           thresholds    //过载阈值
+	overloaded       bool          //负载超过阈值，暂停分配
+	adminState       adminState    //管理状态，排空和维护时不分配新请求
+	drainReported    bool          //排空完成是否已经记录
+	stateTime        time.Time     //最近一次收到健康检查状态的时间
 }
 
 type UserPolicy struct {
-	UserID       string       //用户id，全局唯一
-	Priority     int          //用户优先级
-	Ip           serverIP     //给此用户分配的服务器IP地址
-	ResponseChan *chan string //针对用户策略的回复通道。
+	UserID        string         //用户id，全局唯一
+	Priority      int            //用户优先级
+	Ip            serverIP       //给此用户分配的服务器IP地址，指定了多台服务器时为第一台
+	Ips           []serverIP     //按顺序优先使用的服务器IP地址列表
+	Fallback      pinnedFallback //指定的服务器都不可用时：fail 回复失败，schedule 正常调度
+	Rate          float64        //每秒最多的请求数，0表示不限制
+	Burst         int            //令牌桶容量，允许的突发请求数，0表示1秒的请求数
+	MaxConcurrent int            //同时进行的最多任务数，0表示不限制
+	Weight        int            //同一优先级内按用户公平排队的权重，0表示1
+	ResponseChan  *chan string   //针对用户策略的回复通道。
 }
 
 type serverIP string
 type userID string
 
-//当前只支持轮询方式
+//选择服务器的方式，每种任务类型可以在配置文件[select_mode]中单独指定
 type selectServerMode string
 
 const (
 	CHash      = selectServerMode("chash")
 	RoundRobin = selectServerMode("roundrobin")
 	Hash       = selectServerMode("hash")
+	LoadAware  = selectServerMode("loadaware")
+	LeastConn  = selectServerMode("leastconn")
 )
 
 type strategy struct {
-	servers           map[serverIP]*server         //服务器集合
-	usersPolicy       map[userID]*UserPolicy       //用户策略集合
-	stateChannel      chan *ServerState            // 接收服务器状态通知的通道
-	userPolicyChannel chan *UserPolicy             //接收用户策略通道
-	slbReqChannel     chan *ReqSlbTask             //接收负载均衡请求通道
-	configChannel     chan *config.Configuration   //接收配置文件通道
-	quit              chan bool                    //服务退出通知通道
-	prioriQueue       *priorityQueue               //优先级队列
-	taskQueue         *taskCategoryQueue           //任务类别队列
-	rr                map[taskProperty]*roundRobin //轮询调度
+	servers           map[serverIP]*server             //服务器集合
+	usersPolicy       map[userID]*UserPolicy           //用户策略集合
+	stateChannel      chan *ServerState                // 接收服务器状态通知的通道
+	userPolicyChannel chan *UserPolicy                 //接收用户策略通道
+	slbReqChannel     chan *ReqSlbTask                 //接收负载均衡请求通道
+	configChannel     chan *config.Configuration       //接收配置文件通道
+	leaseChannel      chan *ReqLeaseTask               //接收续约和释放租约请求通道
+	adminChannel      chan *ReqAdminTask               //接收服务器管理状态请求通道
+	explainChannel    chan *ReqExplainTask             //接收解释选机请求通道
+	snapshotChannel   chan *ReqSnapshotTask            //接收查询快照请求通道
+	policyChannel     chan *ReqPolicyTask              //接收查询和删除用户策略请求通道
+	quit              chan bool                        //服务退出通知通道
+	prioriQueue       *priorityQueue                   //优先级队列
+	taskQueue         *taskCategoryQueue               //任务类别队列
+	balancers         map[taskProperty]Balancer        //每种任务类型的负载均衡算法
+	fallbacks         map[taskProperty][]*fallbackRule //每种任务类型的降级链
+	slotHold          time.Duration                    //selectServer模式下转码槽位的占用时长
+	leases            map[string]*lease                //未释放的租约
+	quotas            map[userID]*userQuota            //用户配额的运行状态
+	defaultLeaseTTL   time.Duration                    //租约默认时长
+	slowStart         time.Duration                    //服务器没有单独配置时的慢启动时长
+	outlier           outlierConfig                    //被动健康检查配置
+	retry             retryConfig                      //doWork模式重试配置
+	retryBudget       *retryBudget                     //doWork模式重试预算
 	myDBOperator      *(config.DBOperator)
-	lock              sync.Mutex //在config文件发过来server信息的更新信息后，由于有可能正在处理查询服务器的请求。要加锁
+	policyStore       policyStore //用户策略的持久化
+	adminStore        adminStore  //服务器管理状态的持久化
+	lock              sync.Mutex  //在config文件发过来server信息的更新信息后，由于有可能正在处理查询服务器的请求。要加锁
 }
 
 func NewUserPolicy() *UserPolicy {
@@ -116,10 +180,16 @@ func NewStrategy() *strategy {
 	s := &strategy{}
 	s.servers = make(map[serverIP]*server)
 	s.usersPolicy = make(map[userID]*UserPolicy)
+	s.quotas = make(map[userID]*userQuota)
 	s.stateChannel = make(chan *ServerState, 1)
 	s.userPolicyChannel = make(chan *UserPolicy, 1)
 	s.slbReqChannel = make(chan *ReqSlbTask, 1)
 	s.configChannel = make(chan *config.Configuration, 1)
+	s.leaseChannel = make(chan *ReqLeaseTask, 1)
+	s.adminChannel = make(chan *ReqAdminTask, 1)
+	s.explainChannel = make(chan *ReqExplainTask, 1)
+	s.snapshotChannel = make(chan *ReqSnapshotTask, 1)
+	s.policyChannel = make(chan *ReqPolicyTask, 1)
 	s.quit = make(chan bool, 1)
 	s.taskQueue = NewTaskCategoryList()
 	s.prioriQueue = NewPriorityList(s.taskQueue)
@@ -130,8 +200,18 @@ func NewStrategy() *strategy {
 	// 没有config文件了，也不需要初始化initBackends
 	//s.initBackends(cf)
 
-	s.rr = make(map[taskProperty]*roundRobin) //轮询调度
-	s.updateRR()
+	s.slowStart = readSlowStart()
+	s.outlier = readOutlierConfig()
+	s.retry = readRetryConfig()
+	s.retryBudget = newRetryBudget(s.retry.budgetRatio)
+	s.balancers = make(map[taskProperty]Balancer)
+	s.fallbacks = make(map[taskProperty][]*fallbackRule)
+	s.lock.Lock()
+	s.updateBalancers()
+	s.lock.Unlock()
+	s.slotHold = readSlotHold()
+	s.leases = make(map[string]*lease)
+	s.defaultLeaseTTL = readLeaseTTL()
 
 	s.taskQueue.strategy = s
 
@@ -146,10 +226,14 @@ func (s *strategy) initPolicy() {
 
 	s.myDBOperator = config.NewDBOperator()
 	s.myDBOperator.InitSqlParam()
+	s.policyStore = s.myDBOperator
+	s.adminStore = s.myDBOperator
 	err, result, dbString := s.myDBOperator.QueryPolicyTab()
 	if nil == err && 0 == result {
 
 		utils.Log.Debug("dbString =%s", dbString)
+	} else {
+		utils.Log.Error("load user policy from db failed, no user policy loaded")
 	}
 	var ss [](config.PolicyInfo)
 
@@ -164,33 +248,83 @@ func (s *strategy) initPolicy() {
 		if nil == err {
 			body.Priority = temp
 		}
-		body.Ip = (serverIP)(ss[i].Ip)
+		//优先级超出0-9会导致请求无法排队，修改后才加载
+		if !validPriority(body.Priority) {
+			utils.Log.Error("user:%s priority:%d is not in 0-9, ignore", body.UserID, body.Priority)
+			continue
+		}
+		body.Ips = splitPinned(ss[i].Ip)
+		body.Fallback = pinnedFallback(ss[i].Fallback)
+		body.normalizePinned()
+		//配额为可选项，没有填写时不限制
+		body.Rate, _ = strconv.ParseFloat(ss[i].Rate, 64)
+		body.Burst, _ = strconv.Atoi(ss[i].Burst)
+		body.MaxConcurrent, _ = strconv.Atoi(ss[i].MaxConcurrent)
+		body.Weight, _ = strconv.Atoi(ss[i].Weight)
 		s.usersPolicy[userID(body.UserID)] = body
 	}
 	utils.Log.Debug("len of s.usersPolicy  =%s", len(s.usersPolicy))
 }
 
-//初始化轮询策略
-func (s *strategy) updateRR() {
+//服务器所属的调度池，即服务器的Specialty，没有配置时属于CPU池
+func poolOf(v *server) taskProperty {
+	if v.specialty == "" {
+		return CpuPro
+	}
+	return v.specialty
+}
 
-	defer utils.DealPanic()
+//配置中出现的全部任务类型
+func (s *strategy) poolTypes() map[taskProperty]bool {
+	types := make(map[taskProperty]bool)
+	for _, v := range s.servers {
+		types[poolOf(v)] = true
+	}
+	return types
+}
 
-	var cpuServer []*server
-	var gpuServer []*server
-	s.lock.Lock()
+//某个调度池里的全部服务器
+func (s *strategy) poolServers(tp taskProperty) []*server {
+
+	var servers []*server
 	for _, v := range s.servers {
-		if v.specialty == CpuPro {
-			utils.Log.Debug("cpu: %+v", v)
-			cpuServer = append(cpuServer, v)
-		} else {
-			gpuServer = append(gpuServer, v)
-			utils.Log.Debug("gpu: %+v", v)
+		if poolOf(v) == tp {
+			utils.Log.Debug("%s: %+v", string(tp), v)
+			servers = append(servers, v)
 		}
 	}
-	s.rr[GpuPro] = NewRoundRobin(gpuServer)
-	s.rr[CpuPro] = NewRoundRobin(cpuServer)
-	s.lock.Unlock()
+	sortServers(servers)
+	return servers
+}
+
+//按配置中的任务类型创建和删除任务队列及负载均衡算法，服务器集合变化后重建。调用者需要持有s.lock
+func (s *strategy) updateBalancers() {
+
+	defer utils.DealPanic()
 
+	types := s.poolTypes()
+	for tp := range types {
+		if _, ok := s.balancers[tp]; !ok {
+			utils.Log.Info("new task type:%s", string(tp))
+			s.balancers[tp] = newBalancer(tp)
+			s.fallbacks[tp] = readFallback(tp)
+			s.taskQueue.addCategory(tp)
+		}
+		s.balancers[tp].Rebuild(s.poolServers(tp))
+	}
+
+	//配置中已经没有此类服务器，排队中的请求回复任务类型不存在
+	for tp := range s.balancers {
+		if types[tp] {
+			continue
+		}
+		utils.Log.Info("retire task type:%s", string(tp))
+		delete(s.balancers, tp)
+		delete(s.fallbacks, tp)
+		for _, r := range s.taskQueue.removeCategory(tp) {
+			s.slbResponseToClient(r, &responseSlbReq{RetCode: retCodeNoTaskServer})
+		}
+	}
 }
 
 //初始化后端服务器
@@ -221,8 +355,14 @@ func (s *strategy) Run() {
 	go s.prioriQueue.run()
 	s.taskQueue.run()
 
+	//定时回收到期的转码槽位
+	reclaimTicker := time.NewTicker(time.Second)
+	defer reclaimTicker.Stop()
+
 	for {
 		select {
+		case now := <-reclaimTicker.C:
+			s.reclaimSlots(now)
 		case req := <-s.slbReqChannel:
 			s.dealSlbReq(req)
 		case up := <-s.userPolicyChannel:
@@ -231,6 +371,16 @@ func (s *strategy) Run() {
 			s.dealUpdateServerState(state)
 		case state := <-s.configChannel:
 			s.dealUpdateConfig(state)
+		case req := <-s.leaseChannel:
+			s.dealLease(req)
+		case req := <-s.adminChannel:
+			s.dealAdmin(req)
+		case req := <-s.explainChannel:
+			s.dealExplain(req)
+		case req := <-s.snapshotChannel:
+			s.dealSnapshot(req)
+		case req := <-s.policyChannel:
+			s.dealPolicy(req)
 		case <-s.quit:
 			utils.Log.Debug("strategy run exit")
 			return
@@ -251,7 +401,12 @@ func (s *strategy) AddSlbReq(r *ReqSlbTask) {
 
 	defer utils.DealPanic()
 	r.TaskType = strings.ToUpper(r.TaskType)
-	s.slbReqChannel <- r
+	r.Codec = codecType(strings.ToUpper(string(r.Codec)))
+	select {
+	case s.slbReqChannel <- r:
+	case <-r.context().Done():
+		countTimeout(r, "strategy")
+	}
 }
 
 func (s *strategy) UpdateUserPolicy(up *UserPolicy) {
@@ -264,6 +419,33 @@ func (s *strategy) UpdateConfig(up *config.Configuration) {
 	s.configChannel <- up
 }
 
+func (s *strategy) UpdateLease(req *ReqLeaseTask) {
+	defer utils.DealPanic()
+	s.leaseChannel <- req
+}
+
+func (s *strategy) UpdateAdmin(req *ReqAdminTask) {
+	defer utils.DealPanic()
+	s.adminChannel <- req
+}
+
+func (s *strategy) ExplainSlbReq(req *ReqExplainTask) {
+	defer utils.DealPanic()
+	req.Req.TaskType = strings.ToUpper(req.Req.TaskType)
+	req.Req.Codec = codecType(strings.ToUpper(string(req.Req.Codec)))
+	s.explainChannel <- req
+}
+
+func (s *strategy) Snapshot(req *ReqSnapshotTask) {
+	defer utils.DealPanic()
+	s.snapshotChannel <- req
+}
+
+func (s *strategy) ManageUserPolicy(req *ReqPolicyTask) {
+	defer utils.DealPanic()
+	s.policyChannel <- req
+}
+
 func (s *strategy) UpdateServerState(ss *ServerState) {
 	defer utils.DealPanic()
 	s.stateChannel <- ss
@@ -272,39 +454,43 @@ func (s *strategy) UpdateServerState(ss *ServerState) {
 func (s *strategy) dealSlbReq(r *ReqSlbTask) {
 	defer utils.DealPanic()
 	up, ok := s.usersPolicy[userID(r.UserID)]
-
 	if ok {
-		if up.Ip == "" {
-			r.priority = (priorityLevel)(up.Priority)
-		} else {
-			server := s.servers[up.Ip]
-			//response := &responseSlbReq{}
-			var strIpPort string
-			if nil == server {
-				//如果客户通过 curl -i -d '{"UserID":"123456","Priority":5,"Ip":"192.168.1.252"}' http://192.168.59.128:8081/yfy/user/policy
-				//指定了一个无效ip， 那么当用户"123456"再来请求时  server := s.servers[up.Ip] 就是空
-				//response.RetCode = retCodeFail
-				strIpPort = ""
-				utils.Log.Debug(" the userID ip(%s) did not in serverlist", up.Ip)
-			} else {
-				if server.state.Down {
-					strIpPort = ""
-					//strIpPort = server.ip + ":" + strconv.Itoa(server.port)
-				} else {
-					//response.RetCode = retCodeSuccess
-					//response.IP = server.ip
-					strIpPort = server.ip + ":" + strconv.Itoa(server.port)
-				}
-			}
+		r.priority = (priorityLevel)(up.Priority)
+		r.weight = up.Weight
+		r.trace("user policy matched, priority %d, fair queuing weight %v", up.Priority, r.fairWeight())
+	} else {
+		r.trace("no user policy, priority %d", int(r.priority))
+	}
 
-			//responseToClient(r.ResponseChan, response)
-			ipPortSendToClient(r.ResponseChan, strIpPort)
+	//超过用户配额的请求不进入队列
+	if ok {
+		if response := s.admitQuota(r, up, time.Now()); response != nil {
+			s.slbResponseToClient(r, response)
 			return
 		}
+		if len(up.Ips) > 0 && s.dealPinned(r, up) {
+			return
+		}
+	}
+
+	//没有此类服务器的任务类型直接回复错误，不再进入队列等到超时
+	if !s.taskQueue.hasCategory(taskProperty(r.TaskType)) {
+		utils.Log.Debug("unknown task type:%s", r.TaskType)
+		r.trace("no server pool for task type %s", r.TaskType)
+		s.slbResponseToClient(r, &responseSlbReq{RetCode: retCodeNoTaskServer})
+		return
+	}
+
+	//解释选机时不进入队列，直接按队列协程的流程选机
+	if r.dryRun() {
+		s.SelectServer(r)
+		return
 	}
 
 	utils.Log.Debug(" the userID is did not in the usersPolicy so should add in the prioriQueue list ", r)
-	s.prioriQueue.listAdd(r)
+	if !s.prioriQueue.listAdd(r) {
+		s.slbResponseToClient(r, &responseSlbReq{RetCode: retCodeQueueFull})
+	}
 }
 
 //返回slb获取的分配的服务器给客户端
@@ -317,12 +503,17 @@ func responseToClient(responseChan *chan string, response interface{}) {
 	utils.Log.Debug(response)
 }
 
-//对于proxy类型的请求， 发送Ip字符串到engine 模块
-func ipPortSendToClient(responseChan *chan string, IPString string) {
+//发送选机结果到engine 模块，engine已经放弃等待时释放本次占用的资源。
+//选机成功时调用者需要持有s.lock
+func (s *strategy) slbResponseToClient(r *ReqSlbTask, response *responseSlbReq) {
 	defer utils.DealPanic()
-	utils.Log.Debug("before  -> SelectServer ip port:%s", IPString)
-	*responseChan <- IPString
-	utils.Log.Debug("after -> SelectServer ip port:%s", IPString)
+	utils.Log.Debug("before  -> SelectServer retCode:%d ip port:%s", response.RetCode, response.IP)
+	if !r.deliver(response) {
+		s.releaseOccupied(r)
+		countTimeout(r, "response")
+		return
+	}
+	utils.Log.Debug("after -> SelectServer retCode:%d ip port:%s", response.RetCode, response.IP)
 }
 
 //处理用户策略设置
@@ -340,38 +531,44 @@ func (s *strategy) dealUpdateUserPolicy(up *UserPolicy) {
 		return
 	}
 
-	if "" != up.Ip {
-		//正则匹配 1~255.0~255.0~255.0~255
-		const lostPattern = "^(1\\d{2}|2[0-4]\\d|25[0-5]|[1-9]\\d|[1-9])\\." + "(1\\d{2}|2[0-4]\\d|25[0-5]|[1-9]\\d|\\d)\\." + "(1\\d{2}|2[0-4]\\d|25[0-5]|[1-9]\\d|\\d)\\." + "(1\\d{2}|2[0-4]\\d|25[0-5]|[1-9]\\d|\\d)$"
-		reg, _ := regexp.Compile(lostPattern)
-		if false == reg.MatchString(string(up.Ip)) {
-			utils.Log.Debug("IP is not right: %s", string(up.Ip))
-			response := &responsePolicyReq{}
-			response.RetCode = retCodeFail
-			response.Result = "fail,the ip style is not right"
-			responseToClient(up.ResponseChan, response)
-			return
-		}
-		//看看用户设置的IP是不是在服务器集合里面
-
-		_, ok := s.servers[serverIP(up.Ip)]
-		if !ok {
-			utils.Log.Debug("the user  IP is not in the servers list: %s", string(up.Ip))
-			response := &responsePolicyReq{}
-			response.RetCode = retCodeFail
-			response.Result = "fail,the user`s IP is not in the servers list"
-			responseToClient(up.ResponseChan, response)
-			return
-		}
-	} else {
+	up.normalizePinned()
+	if result := s.checkPinned(up); result != "" {
+		response := &responsePolicyReq{}
+		response.RetCode = retCodeFail
+		response.Result = result
+		responseToClient(up.ResponseChan, response)
+		return
+	}
+	if len(up.Ips) == 0 {
 		utils.Log.Debug("the user  IP null, so the Priority will be affect ", string(up.Ip))
 	}
 
-	s.usersPolicy[userID(up.UserID)] = up
+	if !validPriority(up.Priority) {
+		response := &responsePolicyReq{}
+		response.RetCode = retCodeFail
+		response.Result = "fail,the Priority should be 0-9"
+		responseToClient(up.ResponseChan, response)
+		return
+	}
+
+	if up.Rate < 0 || up.Burst < 0 || up.MaxConcurrent < 0 || up.Weight < 0 {
+		response := &responsePolicyReq{}
+		response.RetCode = retCodeFail
+		response.Result = "fail,the Rate, Burst, MaxConcurrent and Weight should not be negative"
+		responseToClient(up.ResponseChan, response)
+		return
+	}
 
-	//添加到数据库里面
+	//先添加到数据库里面，成功后再修改内存，保持一致
+	if err, _ := s.policyStore.QueryAndInsertPolicyTab(up.UserID, up.Priority, joinPinned(up.Ips), string(up.Fallback), up.Rate, up.Burst, up.MaxConcurrent, up.Weight); err != nil {
+		response := &responsePolicyReq{}
+		response.RetCode = retCodeFail
+		response.Result = "fail,save to db failed"
+		responseToClient(up.ResponseChan, response)
+		return
+	}
 
-	s.myDBOperator.QueryAndInsertPolicyTab(up.UserID, up.Priority, string(up.Ip))
+	s.usersPolicy[userID(up.UserID)] = up
 
 	for k, v := range s.usersPolicy {
 		fmt.Println(k, v)
@@ -388,6 +585,10 @@ func (s *strategy) dealUpdateConfig(up *config.Configuration) {
 
 	defer utils.DealPanic()
 
+	//任务队列协程选机时会读取服务器集合，更新期间一直持有锁
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	// 比对更新数据很繁琐，易出错
 	//1 更新 strategy.servers           map[serverIP]*server //服务器集合
 
@@ -399,6 +600,15 @@ func (s *strategy) dealUpdateConfig(up *config.Configuration) {
 			s.servers[(serverIP)(serverCfg.Host)].specialty = taskProperty(strings.ToUpper(serverCfg.Specialty))
 			s.servers[(serverIP)(serverCfg.Host)].weight = serverCfg.Weight
 			s.servers[(serverIP)(serverCfg.Host)].port = serverCfg.Serverport
+			s.servers[(serverIP)(serverCfg.Host)].H264Capacity = serverCfg.H264Capacity
+			s.servers[(serverIP)(serverCfg.Host)].H265Capacity = serverCfg.H265Capacity
+			s.servers[(serverIP)(serverCfg.Host)].slowStart = s.slowStartOf(serverCfg.SlowStart)
+			s.servers[(serverIP)(serverCfg.Host)].limits = backendThresholds(poolOf(s.servers[(serverIP)(serverCfg.Host)]), &serverCfg)
+			s.servers[(serverIP)(serverCfg.Host)].updateOverload(s.servers[(serverIP)(serverCfg.Host)].state)
+			if state, ok := parseAdminState(serverCfg.AdminState); ok && state != s.servers[(serverIP)(serverCfg.Host)].adminState {
+				s.servers[(serverIP)(serverCfg.Host)].adminState = state
+				s.servers[(serverIP)(serverCfg.Host)].drainReported = false
+			}
 			utils.Log.Debug("Update old server ip:%s", s.servers[serverIP(serverCfg.Host)].ip)
 
 		} else {
@@ -409,6 +619,11 @@ func (s *strategy) dealUpdateConfig(up *config.Configuration) {
 			stem.port = serverCfg.Serverport
 			stem.specialty = taskProperty(strings.ToUpper(serverCfg.Specialty))
 			stem.weight = serverCfg.Weight
+			stem.H264Capacity = serverCfg.H264Capacity
+			stem.H265Capacity = serverCfg.H265Capacity
+			stem.slowStart = s.slowStartOf(serverCfg.SlowStart)
+			stem.limits = backendThresholds(poolOf(&stem), &serverCfg)
+			stem.adminState, _ = parseAdminState(serverCfg.AdminState)
 
 			stem.state = &ServerState{
 				Down: true,
@@ -438,11 +653,8 @@ func (s *strategy) dealUpdateConfig(up *config.Configuration) {
 		}
 	}
 
-	//3 更新 strategy.rr 里面的服务器集合
-	//清空cpu gpu 两个切片里面的内容。
-	s.rr[GpuPro].servers = nil
-	s.rr[CpuPro].servers = nil
-	s.updateRR()
+	//3 更新 strategy.balancers 里面的服务器集合
+	s.updateBalancers()
 
 }
 
@@ -452,43 +664,151 @@ func (s *strategy) dealUpdateServerState(state *ServerState) {
 	defer utils.DealPanic()
 	fmt.Println("dealUpdateServerState")
 	if v, ok := s.servers[serverIP(state.Ip)]; ok {
+		s.lock.Lock()
+		before := checkSeverState(v)
+		v.markRecover(v.state, state, time.Now())
+		v.updateOverload(state)
 		v.state = state
+		v.stateTime = time.Now()
+		//服务器可用状态发生变化，重建对应调度池的负载均衡数据
+		if before != checkSeverState(v) {
+			s.rebuildPool(poolOf(v))
+		}
+		s.lock.Unlock()
 		utils.Log.Debug("server:%v,state:%v", v, v.state)
 	} else {
 		utils.Log.Error("not exit server:%s", state.Ip)
 	}
 }
 
+//按任务类型配置的负载均衡算法获取后端服务器，调用者需要持有s.lock
+func (s *strategy) getBackendServer(r *ReqSlbTask) (*server, retCode, error) {
+
+	defer utils.DealPanic()
+	tp := taskProperty(r.TaskType)
+	b, ok := s.balancers[tp]
+	if !ok {
+		r.trace("no server pool for task type %s", r.TaskType)
+		return nil, retCodeNoTaskServer, errors.New("no corresponding server list")
+	}
+
+	if !validCodec(r.Codec) {
+		r.trace("codec %s is not supported", string(r.Codec))
+		return nil, retCodeFail, errors.New("not support codec " + string(r.Codec))
+	}
+
+	r.fallback = false
+	ser, err := pickServer(b, r)
+	if err != nil && !s.poolHealthy(tp) {
+		//调度池没有正常的服务器，按降级链到其他调度池选机
+		r.trace("pool %s has no healthy server", string(tp))
+		if ser, err = s.pickFallback(r, tp); err == nil {
+			r.fallback = true
+		}
+	}
+	if err != nil {
+		//有正常的服务器能处理此编码，但是槽位都已占满
+		if r.Codec != "" && s.poolSupportCodec(tp, r.Codec) {
+			r.trace("all %s slots are in use", string(r.Codec))
+			return nil, retCodeServerFull, errors.New("no free " + string(r.Codec) + " slot")
+		}
+		r.trace("balancer found no available server")
+		return nil, retCodeServerBusy, err
+	}
+
+	r.trace("balancer chose %s", ser.ip)
+	return ser, retCodeSuccess, nil
+}
+
+//调度池中是否有正常的服务器能处理此编码，调用者需要持有s.lock
+func (s *strategy) poolSupportCodec(tp taskProperty, codec codecType) bool {
+	for _, v := range s.poolServers(tp) {
+		if checkSeverState(v) && v.slotCapacity(codec) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//占用选中服务器的转码槽位：doWork模式在代理结束时释放；selectServer模式申请了租约时
+//由租约释放，没有申请租约时到期自动回收。解释选机时只填写回复，不占用资源。调用者需要持有s.lock
+func (s *strategy) occupy(r *ReqSlbTask, ser *server, response *responseSlbReq) {
+	response.Pool = string(poolOf(ser))
+	response.Fallback = r.fallback
+	if r.dryRun() {
+		return
+	}
+	r.target = ser
+	if r.ReqMode == DoWork {
+		ser.inflight++
+		r.slot = ser.acquireSlot(r, time.Time{})
+	} else if r.Lease {
+		r.lease = s.newLease(r, ser)
+		response.Lease = r.lease.id
+		response.TTL = int(r.lease.ttl / time.Second)
+	} else {
+		r.slot = ser.acquireSlot(r, time.Now().Add(s.slotHold))
+	}
+}
+
+//释放请求占用的资源，重复调用无影响。调用者需要持有s.lock
+func (s *strategy) releaseOccupied(r *ReqSlbTask) {
+	if r.target == nil {
+		return
+	}
+
+	if r.lease != nil {
+		s.releaseLease(r.lease)
+	}
+	if r.ReqMode == DoWork {
+		r.target.inflight--
+	}
+	r.slot.release()
+	r.lease = nil
+	r.slot = nil
+	r.target = nil
+}
+
+//回收selectServer模式下到期的转码槽位和租约，被摘除的服务器到期后重新加入调度池
+func (s *strategy) reclaimSlots(now time.Time) {
+	defer utils.DealPanic()
+
+	s.lock.Lock()
+	for _, v := range s.servers {
+		v.reclaimSlots(now)
+	}
+	s.reclaimLeases(now)
+	s.restoreEjected(now)
+	s.checkDrained(now)
+	s.lock.Unlock()
+}
+
 //确定某台机器进行服务
 func (s *strategy) SelectServer(r *ReqSlbTask) {
 	defer utils.DealPanic()
 
-	//response := &responseSlbReq{}
-	//response.RetCode = retCodeServerBusy
-	var strIpPort string
+	response := &responseSlbReq{}
 	s.lock.Lock()
-	v, ok := s.rr[taskProperty(r.TaskType)]
-	if !ok {
-		utils.Log.Error("req task type:%s no corresponding server list")
-		//请求任务类型未对应服务器链表，则使用CPU类型的的链表
-		//response.RetCode = retCodeNoTaskServer
-		strIpPort = ""
-	} else {
-		ser, err := v.getBackendServer()
-		if err != nil {
+	defer s.lock.Unlock()
+	//engine已经放弃等待，不再消耗一次选机
+	if r.cancelled() {
+		countTimeout(r, "select")
+		return
+	}
 
-			utils.Log.Debug("SelectServer:%s", err.Error())
-			strIpPort = ""
+	ser, code, err := s.getBackendServer(r)
+	response.RetCode = code
+	if err != nil {
 
-		} else {
-			strIpPort = ser.ip + ":" + strconv.Itoa(ser.port)
+		utils.Log.Debug("SelectServer task type:%s, %s", r.TaskType, err.Error())
+
+	} else {
+		s.occupy(r, ser, response)
+		response.IP = ser.ip + ":" + strconv.Itoa(ser.port)
 
-		}
 	}
-	s.lock.Unlock()
-	//responseToClient(r.ResponseChan, response)
-	utils.Log.Debug("SelectServer ip port:%s", strIpPort)
-	ipPortSendToClient(r.ResponseChan, strIpPort)
+	utils.Log.Debug("SelectServer ip port:%s", response.IP)
+	s.slbResponseToClient(r, response)
 
 }
 
@@ -496,22 +816,38 @@ func (s *strategy) SelectServer(r *ReqSlbTask) {
 func (s *strategy) DoWork(r *ReqSlbTask) {
 	defer utils.DealPanic()
 
+	response := &responseSlbReq{}
 	s.lock.Lock()
-	var strIpPort string
-	v, ok := s.rr[taskProperty(r.TaskType)]
-	if !ok {
-		utils.Log.Error("req task type:%s no corresponding server list")
-		//请求任务类型未对应服务器链表，则使用CPU类型的的链表
-		//l, ok := s.serverSpecialtyList[CpuPro]
+	defer s.lock.Unlock()
+	//engine已经放弃等待，不再消耗一次选机
+	if r.cancelled() {
+		countTimeout(r, "dowork")
+		return
+	}
+
+	s.retryBudget.deposit()
+	ser, code, err := s.getBackendServer(r)
+	response.RetCode = code
+	if err != nil {
+		utils.Log.Debug("DoWork task type:%s, %s", r.TaskType, err.Error())
 	} else {
-		ser, err := v.getBackendServer()
-		if err != nil {
-			utils.Log.Debug("1111111SelectServer:%s", err.Error())
-		} else {
-			strIpPort = ser.ip + ":" + strconv.Itoa(ser.port)
-			utils.Log.Debug("2222222SelectServer:%s", strIpPort)
-		}
+		s.occupy(r, ser, response)
+		response.IP = ser.ip + ":" + strconv.Itoa(ser.port)
+		utils.Log.Debug("DoWork SelectServer:%s", response.IP)
+	}
+	s.slbResponseToClient(r, response)
+}
+
+//doWork模式代理结束后，释放转码槽位，并把处理结果反馈给被动健康检查
+func (s *strategy) FinishWork(r *ReqSlbTask, ok bool) {
+	defer utils.DealPanic()
+
+	r.hold.release()
+	if r.target == nil {
+		return
 	}
-	ipPortSendToClient(r.ResponseChan, strIpPort)
+
+	s.lock.Lock()
+	s.finishAttempt(r, ok)
 	s.lock.Unlock()
 }
@@ -0,0 +1,139 @@
+package strategy
+
+//转码资源(槽位)管理：每台服务器按编码类型声明能同时处理的任务数，
+//选机时只选择还有空闲槽位的服务器
+import (
+	"common/utils"
+	"strconv"
+	"time"
+)
+
+type codecType string
+
+const (
+	H264 = codecType("H264")
+	H265 = codecType("H265")
+)
+
+//selectServer模式下slb不知道任务何时结束，槽位默认占用的时长，单位秒
+const defaultSlotHoldSeconds int = 300
+
+//服务器上被占用的一个转码槽位
+type taskSlot struct {
+	server   *server   //所在服务器
+	codec    codecType //编码类型
+	expire   time.Time //到期自动回收，零值表示由doWork结束时释放
+	fallback bool      //是否被降级请求占用
+}
+
+//请求中的编码类型是否支持，为空表示不占用转码资源
+func validCodec(codec codecType) bool {
+	return codec == "" || codec == H264 || codec == H265
+}
+
+//selectServer模式下槽位占用时长，从配置文件[slot]的hold_seconds读取
+func readSlotHold() time.Duration {
+
+	defer utils.DealPanic()
+	hold, err := strconv.Atoi(utils.ConfigFile.Read_string("slot", "hold_seconds", strconv.Itoa(defaultSlotHoldSeconds)))
+	if err != nil || hold <= 0 {
+		utils.Log.Error("slot hold_seconds is not right, use %d", defaultSlotHoldSeconds)
+		hold = defaultSlotHoldSeconds
+	}
+	return time.Duration(hold) * time.Second
+}
+
+func (s *server) slotCapacity(codec codecType) int {
+	switch codec {
+	case H264:
+		return s.H264Capacity
+	case H265:
+		return s.H265Capacity
+	}
+	return 0
+}
+
+func (s *server) slotList(codec codecType) *[]*taskSlot {
+	switch codec {
+	case H264:
+		return &s.H264TaskSlice
+	case H265:
+		return &s.H265TaskSlice
+	}
+	return &s.fallbackTasks
+}
+
+//是否还有空闲槽位，编码类型为空时不限制；容量为0表示此服务器不处理该编码
+func (s *server) hasFreeSlot(codec codecType) bool {
+	if codec == "" {
+		return true
+	}
+	return len(*s.slotList(codec)) < s.slotCapacity(codec)
+}
+
+//为请求占用一个槽位，编码类型为空时返回nil；没有编码类型的降级请求占用一个降级任务
+func (s *server) acquireSlot(r *ReqSlbTask, expire time.Time) *taskSlot {
+	codec := r.Codec
+	if codec == "" && !r.fallback {
+		return nil
+	}
+
+	t := &taskSlot{server: s, codec: codec, expire: expire, fallback: r.fallback}
+	slots := s.slotList(codec)
+	*slots = append(*slots, t)
+	utils.Log.Debug("server:%s acquire %s slot, used:%d capacity:%d", s.ip, string(codec), len(*slots), s.slotCapacity(codec))
+	return t
+}
+
+//释放槽位，重复释放无影响
+func (t *taskSlot) release() {
+	if t == nil {
+		return
+	}
+
+	slots := t.server.slotList(t.codec)
+	for i, v := range *slots {
+		if v == t {
+			*slots = append((*slots)[:i], (*slots)[i+1:]...)
+			utils.Log.Debug("server:%s release %s slot, used:%d", t.server.ip, string(t.codec), len(*slots))
+			return
+		}
+	}
+}
+
+//没有申请租约的selectServer请求占用的未到期槽位数，doWork和租约的槽位不会到期，已经计入inflight
+func (s *server) heldSlots(now time.Time) int {
+	n := 0
+	for _, codec := range []codecType{H264, H265, ""} {
+		for _, t := range *s.slotList(codec) {
+			if !t.expire.IsZero() && now.Before(t.expire) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+//回收到期的槽位
+func (s *server) reclaimSlots(now time.Time) {
+	for _, codec := range []codecType{H264, H265, ""} {
+		slots := s.slotList(codec)
+		kept := (*slots)[:0]
+		for _, t := range *slots {
+			if t.expire.IsZero() || now.Before(t.expire) {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) != len(*slots) {
+			utils.Log.Debug("server:%s reclaim %d %s slots", s.ip, len(*slots)-len(kept), string(codec))
+		}
+		*slots = kept
+	}
+}
+
+//请求可以分配到这台服务器：服务器状态正常，有请求编码类型的空闲槽位，并且不是重试前失败的服务器。
+//降级选机时还要检查降级请求可以使用的槽位
+func serverAvailable(s *server, r *ReqSlbTask) bool {
+	return checkSeverState(s) && s.hasFreeSlot(r.Codec) && !r.tried(s) &&
+		(r.fallbackFactor == 0 || s.hasFallbackSlot(r.Codec, r.fallbackFactor))
+}
@@ -0,0 +1,88 @@
+package strategy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlotAcquireAndRelease(t *testing.T) {
+
+	s := newTestServers(1)[0]
+	s.H264Capacity = 2
+
+	r := &ReqSlbTask{Codec: H264}
+	a := s.acquireSlot(r, time.Time{})
+	b := s.acquireSlot(r, time.Time{})
+	if s.hasFreeSlot(H264) || s.hasFreeSlot(H265) {
+		t.Fatal("free slot after capacity used up, or H265 without capacity")
+	}
+
+	//重复释放只归还一个槽位
+	a.release()
+	a.release()
+	if len(s.H264TaskSlice) != 1 || s.H264TaskSlice[0] != b || !s.hasFreeSlot(H264) {
+		t.Fatalf("slots after release %v", s.H264TaskSlice)
+	}
+
+	//没有编码类型的请求不占用槽位，也不受槽位限制
+	if slot := s.acquireSlot(&ReqSlbTask{}, time.Time{}); slot != nil || !s.hasFreeSlot("") {
+		t.Error("request without codec took a slot")
+	}
+}
+
+func TestSlotReclaim(t *testing.T) {
+
+	s := newTestServers(1)[0]
+	s.H265Capacity = 3
+	now := time.Now()
+
+	r := &ReqSlbTask{Codec: H265}
+	work := s.acquireSlot(r, time.Time{})
+	s.acquireSlot(r, now.Add(-time.Second))
+	hold := s.acquireSlot(r, now.Add(time.Minute))
+
+	//只回收到期的槽位，doWork的槽位不会到期
+	s.reclaimSlots(now)
+	if len(s.H265TaskSlice) != 2 || s.H265TaskSlice[0] != work || s.H265TaskSlice[1] != hold {
+		t.Errorf("slots after reclaim %v", s.H265TaskSlice)
+	}
+}
+
+func TestSelectByCodec(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU")
+	s.slotHold = time.Minute
+	s.updateBalancers()
+	s.servers["192.168.1.1"].H264Capacity = 1
+	s.servers["192.168.1.2"].H264Capacity = 1
+
+	//只分配到有空闲槽位的服务器，槽位占满时回复ServerFull
+	for i := 0; i < 2; i++ {
+		r := &ReqSlbTask{TaskType: string(CpuPro), Codec: H264}
+		ser, code, err := s.getBackendServer(r)
+		if err != nil || code != retCodeSuccess {
+			t.Fatalf("pick %d: %d %v", i, code, err)
+		}
+		s.occupy(r, ser, &responseSlbReq{})
+		if r.slot == nil || r.slot.expire.IsZero() {
+			t.Fatalf("selectServer slot %+v has no expire", r.slot)
+		}
+	}
+	if _, code, _ := s.getBackendServer(&ReqSlbTask{TaskType: string(CpuPro), Codec: H264}); code != retCodeServerFull {
+		t.Errorf("full pool retCode %d", code)
+	}
+
+	//没有服务器处理H265时不是槽位占满
+	if _, code, _ := s.getBackendServer(&ReqSlbTask{TaskType: string(CpuPro), Codec: H265}); code != retCodeServerBusy {
+		t.Errorf("no H265 capacity retCode %d", code)
+	}
+	if _, code, _ := s.getBackendServer(&ReqSlbTask{TaskType: string(CpuPro), Codec: "VP9"}); code != retCodeFail {
+		t.Errorf("unsupported codec retCode %d", code)
+	}
+
+	//槽位到期回收后可以再分配
+	s.reclaimSlots(time.Now().Add(2 * time.Minute))
+	if _, code, _ := s.getBackendServer(&ReqSlbTask{TaskType: string(CpuPro), Codec: H264}); code != retCodeSuccess {
+		t.Errorf("after reclaim retCode %d", code)
+	}
+}
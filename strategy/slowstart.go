@@ -0,0 +1,73 @@
+package strategy
+
+//慢启动：健康检查把宕机的服务器恢复为正常后，服务器的有效权重在慢启动时长内
+//从配置权重的slowStartMinPercent%线性增加到100%，避免刚恢复的服务器立刻收到大量请求又被压垮。
+//慢启动时长按服务器配置(slb_svr的slowstart)，为0时使用配置文件[slow_start]的seconds。
+//一致性hash按虚拟节点分配请求，不使用有效权重，不做慢启动
+import (
+	"common/utils"
+	"strconv"
+	"time"
+)
+
+//慢启动开始时的权重比例
+const slowStartMinPercent int = 10
+
+//默认不做慢启动
+const defaultSlowStartSeconds int = 0
+
+func readSlowStart() time.Duration {
+
+	defer utils.DealPanic()
+	seconds, err := strconv.Atoi(utils.ConfigFile.Read_string("slow_start", "seconds", strconv.Itoa(defaultSlowStartSeconds)))
+	if err != nil || seconds < 0 {
+		utils.Log.Error("slow_start seconds is not right, use %d", defaultSlowStartSeconds)
+		seconds = defaultSlowStartSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+//服务器的慢启动时长：服务器没有单独配置时使用默认值
+func (s *strategy) slowStartOf(seconds int) time.Duration {
+	if seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return s.slowStart
+}
+
+//健康检查上报新状态时记录服务器从宕机恢复的时间。
+//服务器加入后第一次变为正常不算恢复，不做慢启动
+func (s *server) markRecover(old, state *ServerState, now time.Time) {
+	if state.Down {
+		return
+	}
+	if old.Down && s.seenUp {
+		s.upSince = now
+		utils.Log.Info("server:%s recover from down, slow start %v", s.ip, s.slowStart)
+	}
+	s.seenUp = true
+}
+
+//慢启动期间的有效权重，慢启动结束后等于配置权重
+func (s *server) rampedWeight(now time.Time) int {
+
+	if s.weight <= 0 || s.slowStart <= 0 || s.upSince.IsZero() {
+		return s.weight
+	}
+
+	elapsed := now.Sub(s.upSince)
+	if elapsed >= s.slowStart {
+		return s.weight
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	//按百分比线性增加，至少为1，服务器仍然能分到少量请求
+	percent := slowStartMinPercent + int(int64(100-slowStartMinPercent)*int64(elapsed)/int64(s.slowStart))
+	w := s.weight * percent / 100
+	if w < 1 {
+		w = 1
+	}
+	return w
+}
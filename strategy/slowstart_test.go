@@ -0,0 +1,56 @@
+package strategy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowStartRamp(t *testing.T) {
+
+	servers := newTestServers(50)
+	s := servers[0]
+	s.slowStart = 100 * time.Second
+	now := time.Now()
+
+	//第一次变为正常不做慢启动
+	s.markRecover(&ServerState{Down: true}, &ServerState{}, now)
+	if w := s.rampedWeight(now); w != 50 {
+		t.Fatalf("first up weight %d, want 50", w)
+	}
+
+	s.markRecover(&ServerState{Down: true}, &ServerState{}, now)
+	cases := []struct {
+		elapsed time.Duration
+		want    int
+	}{
+		{0, 5},
+		{50 * time.Second, 27},
+		{99 * time.Second, 49},
+		{100 * time.Second, 50},
+		{time.Hour, 50},
+	}
+	for _, c := range cases {
+		if w := s.rampedWeight(now.Add(c.elapsed)); w != c.want {
+			t.Errorf("after %v weight %d, want %d", c.elapsed, w, c.want)
+		}
+	}
+}
+
+func TestSlowStartRoundRobinShare(t *testing.T) {
+
+	servers := newTestServers(10, 10)
+	servers[1].slowStart = time.Hour
+	servers[1].seenUp = true
+	servers[1].markRecover(&ServerState{Down: true}, &ServerState{}, time.Now())
+	rr := NewRoundRobin(servers)
+
+	//刚恢复的服务器权重为1，只能分到1/11的请求
+	count := make(map[*server]int)
+	for i := 0; i < 110; i++ {
+		s, _ := rr.getBackendServer(&ReqSlbTask{})
+		count[s]++
+	}
+	if count[servers[1]] != 10 {
+		t.Errorf("recovering server picked %d times, want 10", count[servers[1]])
+	}
+}
@@ -0,0 +1,172 @@
+package strategy
+
+//只读快照：在Run协程中持有s.lock复制全部服务器和调度池的状态，
+//返回服务器配置、最近一次健康检查状态、有效权重、正在处理的任务数和租约数、调度池成员和运行统计等，
+//复制完成后再序列化，不会读到更新到一半的状态
+import (
+	"common/utils"
+	"sort"
+	"time"
+)
+
+//查询快照时，客户端带的参数
+type ReqSnapshotTask struct {
+	Ip           string       //只查询此服务器，为空时查询全部
+	ResponseChan *chan string //针对每个请求的同步回复通道
+}
+
+//一台服务器的快照
+type serverSnapshot struct {
+	Ip               string      //服务器IP
+	Port             int         //服务器工作端口
+	Pool             string      //所在的调度池
+	Weight           int         //配置的权重
+	EffectiveWeight  int         //本轮有效权重：不可用时为0，慢启动期间为慢启动权重
+	H264Capacity     int         //H264能力
+	H265Capacity     int         //H265能力
+	H264Used         int         //H264已占用的槽位
+	H265Used         int         //H265已占用的槽位
+	SlowStart        int         //慢启动时长，单位秒
+	CpuHigh          int         //CPU过载进入值
+	CpuLow           int         //CPU过载退出值
+	MemHigh          int         //内存过载进入值
+	MemLow           int         //内存过载退出值
+	IoHigh           int         //IO等待过载进入值
+	IoLow            int         //IO等待过载退出值
+	AdminState       adminState  //管理状态
+	State            ServerState //最近一次健康检查上报的状态
+	StateTime        string      //最近一次收到健康检查状态的时间，没有收到过时为空
+	Available        bool        //是否可以分配新请求
+	Overloaded       bool        //是否过载
+	Ejected          bool        //是否被被动健康检查摘除
+	EjectUntil       string      //摘除到期时间
+	ConsecutiveFails int         //doWork代理连续失败的次数
+	Inflight         int         //正在处理的任务数，包括doWork代理和未释放的租约
+	Leases           int         //未释放的租约数
+}
+
+//一个调度池的快照
+type poolSnapshot struct {
+	Pool     string   //调度池，即任务类型
+	Servers  []string //调度池中的服务器IP
+	Healthy  int      //可以分配新请求的服务器数
+	Fallback []string //降级链
+	Queued   int      //任务类型队列中排队的请求数
+}
+
+//针对ReqSnapshotTask的回复
+type responseSnapshotReq struct {
+	RetCode retCode          //成功此值是0，失败非0
+	Result  string           //结果描述
+	Time    string           //快照时间
+	Servers []serverSnapshot //服务器快照，按IP排序
+	Pools   []poolSnapshot   //调度池快照，按名称排序
+	Stats   slbStats         //运行统计：摘除、重试、超时丢弃和超过用户配额的次数
+}
+
+func NewReqSnapshotTask() *ReqSnapshotTask {
+	r := &ReqSnapshotTask{}
+	chanStr := make(chan string, 1)
+	r.ResponseChan = &chanStr
+	return r
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+//复制一台服务器的状态，调用者需要持有s.lock
+func (s *strategy) snapshotServer(v *server, now time.Time) serverSnapshot {
+	snap := serverSnapshot{
+		Ip:               v.ip,
+		Port:             v.port,
+		Pool:             string(poolOf(v)),
+		Weight:           v.weight,
+		H264Capacity:     v.H264Capacity,
+		H265Capacity:     v.H265Capacity,
+		H264Used:         len(v.H264TaskSlice),
+		H265Used:         len(v.H265TaskSlice),
+		SlowStart:        int(v.slowStart / time.Second),
+		CpuHigh:          v.limits.cpu.high,
+		CpuLow:           v.limits.cpu.low,
+		MemHigh:          v.limits.mem.high,
+		MemLow:           v.limits.mem.low,
+		IoHigh:           v.limits.io.high,
+		IoLow:            v.limits.io.low,
+		AdminState:       v.adminState,
+		StateTime:        formatTime(v.stateTime),
+		Available:        checkSeverState(v),
+		Overloaded:       v.overloaded,
+		Ejected:          v.ejected(now),
+		ConsecutiveFails: v.consecutiveFails,
+		Inflight:         v.inflight,
+		Leases:           s.leaseCount(v),
+	}
+	if snap.AdminState == "" {
+		snap.AdminState = AdminActive
+	}
+	if v.state != nil {
+		snap.State = *v.state
+	}
+	if snap.Available {
+		snap.EffectiveWeight = v.rampedWeight(now)
+	}
+	if snap.Ejected {
+		snap.EjectUntil = formatTime(v.ejectUntil)
+	}
+	return snap
+}
+
+//复制全部调度池的状态，调用者需要持有s.lock
+func (s *strategy) snapshotPools() []poolSnapshot {
+	var pools []poolSnapshot
+	for tp := range s.poolTypes() {
+		pool := poolSnapshot{Pool: string(tp), Queued: s.taskQueue.queued(tp)}
+		for _, v := range s.poolServers(tp) {
+			pool.Servers = append(pool.Servers, v.ip)
+			if checkSeverState(v) {
+				pool.Healthy++
+			}
+		}
+		for _, rule := range s.fallbacks[tp] {
+			pool.Fallback = append(pool.Fallback, string(rule.pool))
+		}
+		pools = append(pools, pool)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Pool < pools[j].Pool })
+	return pools
+}
+
+//处理查询快照的请求
+func (s *strategy) dealSnapshot(req *ReqSnapshotTask) {
+	defer utils.DealPanic()
+
+	now := time.Now()
+	response := &responseSnapshotReq{Time: formatTime(now), Stats: loadStats()}
+
+	s.lock.Lock()
+	if req.Ip != "" {
+		if v, ok := s.servers[serverIP(req.Ip)]; ok {
+			response.Servers = append(response.Servers, s.snapshotServer(v, now))
+		}
+	} else {
+		for _, v := range s.servers {
+			response.Servers = append(response.Servers, s.snapshotServer(v, now))
+		}
+		response.Pools = s.snapshotPools()
+	}
+	s.lock.Unlock()
+
+	sort.Slice(response.Servers, func(i, j int) bool { return response.Servers[i].Ip < response.Servers[j].Ip })
+	if req.Ip != "" && len(response.Servers) == 0 {
+		response.RetCode = retCodeFail
+		response.Result = "fail,the ip is not in the servers list"
+	} else {
+		response.RetCode = retCodeSuccess
+		response.Result = "success"
+	}
+	responseToClient(req.ResponseChan, response)
+}
@@ -0,0 +1,67 @@
+package strategy
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+)
+
+func querySnapshot(t *testing.T, s *strategy, ip string) *responseSnapshotReq {
+	req := NewReqSnapshotTask()
+	req.Ip = ip
+	s.dealSnapshot(req)
+	res := &responseSnapshotReq{}
+	if err := json.Unmarshal([]byte(<-*req.ResponseChan), res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestSnapshotServersAndPools(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "GPU", "")
+	s.leases = make(map[string]*lease)
+	s.updateBalancers()
+	s.dealUpdateServerState(&ServerState{Ip: "192.168.1.2", CupUtil: 95})
+
+	r := &ReqSlbTask{TaskType: string(CpuPro), ReqMode: DoWork}
+	s.occupy(r, s.servers["192.168.1.1"], &responseSlbReq{})
+
+	res := querySnapshot(t, s, "")
+	if res.RetCode != retCodeSuccess || len(res.Servers) != 3 {
+		t.Fatalf("snapshot %+v", res)
+	}
+	cpu, gpu := res.Servers[0], res.Servers[1]
+	if cpu.Inflight != 1 || !cpu.Available || cpu.EffectiveWeight != 1 || cpu.StateTime != "" || cpu.AdminState != AdminActive {
+		t.Errorf("busy server snapshot %+v", cpu)
+	}
+	if gpu.Pool != "GPU" || !gpu.Overloaded || gpu.Available || gpu.EffectiveWeight != 0 || gpu.State.CupUtil != 95 || gpu.StateTime == "" {
+		t.Errorf("overloaded server snapshot %+v", gpu)
+	}
+
+	if len(res.Pools) != 2 || res.Pools[0].Pool != "CPU" || len(res.Pools[0].Servers) != 2 || res.Pools[1].Healthy != 0 {
+		t.Errorf("pools snapshot %+v", res.Pools)
+	}
+
+	if res = querySnapshot(t, s, "192.168.1.3"); len(res.Servers) != 1 || res.Servers[0].Pool != "CPU" {
+		t.Errorf("single server snapshot %+v", res)
+	}
+	if res = querySnapshot(t, s, "10.0.0.1"); res.RetCode != retCodeFail {
+		t.Errorf("unknown server snapshot %+v", res)
+	}
+}
+
+func TestSnapshotStats(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU")
+	s.leases = make(map[string]*lease)
+	s.updateBalancers()
+
+	before := loadStats()
+	atomic.AddInt64(&stats.Ejections, 1)
+	atomic.AddInt64(&stats.Retries, 2)
+	res := querySnapshot(t, s, "")
+	if res.Stats.Ejections != before.Ejections+1 || res.Stats.Retries != before.Retries+2 {
+		t.Errorf("snapshot stats %+v, before %+v", res.Stats, before)
+	}
+}
@@ -3,25 +3,44 @@ package strategy
 //此队列的作用：分类缓存
 import (
 	"common/utils"
-	"container/list"
-	"reflect"
+	"errors"
+	"strconv"
 	"sync"
-	"time"
 )
 
+//任务类型，和服务器配置的Specialty对应，例如"CPU"、"GPU"、"FPGA"、"H265-HW"
 type taskProperty string
 
+//默认的任务类型，服务器没有配置Specialty时属于CPU
 const (
 	GpuPro = taskProperty("GPU")
 	CpuPro = taskProperty("CPU")
 )
 
-//不同级别优先级的任务放入到不同级别的都列
+//每种任务类型默认最多排队的请求数
+const defaultTaskQueueCapacity int = 4096
+
+var (
+	errQueueFull       = errors.New("queue is full")
+	errUnknownTaskType = errors.New("unknown task type")
+)
+
+//一种任务类型的排队链表和处理协程
+type taskCategory struct {
+	tasks  *fairList     //排队的任务，按用户公平排队
+	signal chan struct{} //有新任务时通知处理协程
+	quit   chan bool     //任务类型被删除或者服务退出时通知处理协程
+}
+
+//不同级别优先级的任务放入到不同级别的都列。
+//任务类型来自服务器配置的Specialty，随配置变化增加和删除
 type taskCategoryQueue struct {
-	lock     sync.Mutex
-	taskList map[taskProperty]*list.List //GPU/CPU两个任务类型链表
-	quits    map[taskProperty]chan bool
-	strategy StrategyInterface //服务器策略
+	lock       sync.Mutex
+	categories map[taskProperty]*taskCategory //任务类型链表
+	running    bool                           //处理协程是否已经启动
+	capacity   int                            //每种任务类型最多排队的请求数
+	spaceFreed func()                         //取走任务后通知优先级队列继续分发
+	strategy   StrategyInterface              //服务器策略
 }
 
 func NewTaskCategoryList() *taskCategoryQueue {
@@ -29,101 +48,177 @@ func NewTaskCategoryList() *taskCategoryQueue {
 	defer utils.DealPanic()
 
 	t := &taskCategoryQueue{}
-	t.taskList = make(map[taskProperty]*list.List)
-	t.taskList[GpuPro] = list.New()
-	t.taskList[CpuPro] = list.New()
-
-	t.quits = make(map[taskProperty]chan bool)
-	t.quits[GpuPro] = make(chan bool, 1)
-	t.quits[CpuPro] = make(chan bool, 1)
+	t.categories = make(map[taskProperty]*taskCategory)
+	t.capacity = readQueueCapacity("task_capacity", defaultTaskQueueCapacity)
 
 	return t
 }
 
+//从配置文件[queue]读取队列容量
+func readQueueCapacity(key string, def int) int {
+
+	defer utils.DealPanic()
+	capacity, err := strconv.Atoi(utils.ConfigFile.Read_string("queue", key, strconv.Itoa(def)))
+	if err != nil || capacity <= 0 {
+		utils.Log.Error("queue %s is not right, use %d", key, def)
+		capacity = def
+	}
+	return capacity
+}
+
 func (t *taskCategoryQueue) quit() {
 	defer utils.DealPanic()
-	for k, _ := range t.quits {
-		t.quits[k] <- true
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, c := range t.categories {
+		c.quit <- true
 	}
+	t.running = false
+}
+
+//是否存在此任务类型
+func (t *taskCategoryQueue) hasCategory(key taskProperty) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	_, ok := t.categories[key]
+	return ok
 }
 
-//任务分类链表
-func (t *taskCategoryQueue) listAdd(task *ReqSlbTask) {
+//增加任务类型，已经存在时不处理
+func (t *taskCategoryQueue) addCategory(key taskProperty) {
 
 	defer utils.DealPanic()
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	q, ok := t.taskList[taskProperty(task.TaskType)]
-	if !ok {
-		utils.Log.Debug("list add err:%s", task.TaskType)
+	if _, ok := t.categories[key]; ok {
 		return
 	}
 
-	utils.Log.Debug("to task queue,type:%s", task.TaskType)
-	q.PushBack(task)
+	c := &taskCategory{tasks: newFairList(), signal: make(chan struct{}, 1), quit: make(chan bool, 1)}
+	t.categories[key] = c
+	if t.running {
+		go t.work(key, c)
+	}
+	utils.Log.Info("add task type:%s", string(key))
 }
 
-func (t *taskCategoryQueue) listRemove(e *list.Element) {
+//删除任务类型，停止处理协程，返回还在排队的任务
+func (t *taskCategoryQueue) removeCategory(key taskProperty) []*ReqSlbTask {
 
 	defer utils.DealPanic()
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	task := e.Value.(*ReqSlbTask)
+	c, ok := t.categories[key]
+	if !ok {
+		return nil
+	}
 
-	q, ok := t.taskList[taskProperty(task.TaskType)]
+	pending := c.tasks.drain()
+	delete(t.categories, key)
+	c.quit <- true
+
+	utils.Log.Info("remove task type:%s, pending:%d", string(key), len(pending))
+	return pending
+}
+
+//某种任务类型排队的请求数
+func (t *taskCategoryQueue) queued(key taskProperty) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if c, ok := t.categories[key]; ok {
+		return c.tasks.Len()
+	}
+	return 0
+}
+
+//任务分类链表，队列已满或者任务类型不存在时返回错误
+func (t *taskCategoryQueue) listAdd(task *ReqSlbTask) error {
+
+	defer utils.DealPanic()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	c, ok := t.categories[taskProperty(task.TaskType)]
 	if !ok {
-		utils.Log.Debug("listRemove err:%s", task.TaskType)
-		return
+		utils.Log.Debug("list add err:%s", task.TaskType)
+		return errUnknownTaskType
 	}
 
-	q.Remove(e)
+	if c.tasks.Len() >= t.capacity {
+		return errQueueFull
+	}
+
+	utils.Log.Debug("to task queue,type:%s", task.TaskType)
+	c.tasks.push(task)
+
+	//通知处理协程，协程正在忙时信号已经存在，不需要重复发送
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+//取出某种类型排在最前面的任务，没有任务时返回nil
+func (t *taskCategoryQueue) pop(c *taskCategory) *ReqSlbTask {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	e := c.tasks.Front()
+	if e == nil {
+		return nil
+	}
+
+	return c.tasks.take(e)
 }
 
 //从不同任务队列中获取任务，触发选择主机流程
 func (t *taskCategoryQueue) run() {
 
 	defer utils.DealPanic()
-	//每次循环最多处理个数，防止此协程持续运行时间过久
-	const maxDealReqNum int = 1024
-
-	for key, v := range t.taskList {
-
-		go func(taskQ *list.List, key taskProperty) {
-			//缩小变量有效空间
-			var dealNum int = 0
-			timeout := time.NewTicker(time.Millisecond * 10)
-
-			for {
-				select {
-				case <-timeout.C:
-
-					dealNum = 0
-					for taskQ.Len() > 0 && dealNum < maxDealReqNum {
-						dealNum++
-						e := taskQ.Front()
-						task, ok := e.Value.(*ReqSlbTask)
-						if !ok {
-							utils.Log.Error("doReq taskQueue:list element not ReqSlbTask, type:%v", reflect.TypeOf(e))
-						} else {
-							utils.Log.Debug("doReq taskQueue: taskQueue len is %d, now is do with: %v ", taskQ.Len(), task)
-
-							if SelectServer == task.ReqMode {
-								t.strategy.SelectServer(task)
-							} else if DoWork == task.ReqMode {
-								t.strategy.DoWork(task)
-
-							}
-
-							t.listRemove(e)
-						}
-					}
-				case <-t.quits[key]:
-					utils.Log.Debug("task type %s run exit", string(key))
-					return
-				}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.running = true
+	for key, c := range t.categories {
+		go t.work(key, c)
+	}
+}
+
+//某种任务类型的处理协程，队列为空时等待新任务的通知，不再定时轮询
+func (t *taskCategoryQueue) work(key taskProperty, c *taskCategory) {
+
+	defer utils.DealPanic()
+	for {
+		task := t.pop(c)
+		if task == nil {
+			select {
+			case <-c.signal:
+				continue
+			case <-c.quit:
+				utils.Log.Debug("task type %s run exit", string(key))
+				return
 			}
-		}(v, key)
+		}
+
+		if t.spaceFreed != nil {
+			t.spaceFreed()
+		}
+
+		//engine已经放弃等待的任务直接丢弃
+		if task.cancelled() {
+			countTimeout(task, "task queue")
+			continue
+		}
+
+		utils.Log.Debug("doReq taskQueue: type:%s, now is do with: %v ", string(key), task)
+		if SelectServer == task.ReqMode {
+			t.strategy.SelectServer(task)
+		} else if DoWork == task.ReqMode {
+			t.strategy.DoWork(task)
+		}
 	}
 }
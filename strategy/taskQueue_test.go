@@ -0,0 +1,116 @@
+package strategy
+
+import (
+	"slb/config"
+	"testing"
+)
+
+func newCategoryTestStrategy(specialty ...string) *strategy {
+	s := &strategy{}
+	s.servers = make(map[serverIP]*server)
+	s.balancers = make(map[taskProperty]Balancer)
+	s.fallbacks = make(map[taskProperty][]*fallbackRule)
+	s.taskQueue = NewTaskCategoryList()
+	for i, v := range newTestServers(make([]int, len(specialty))...) {
+		v.weight = 1
+		v.specialty = taskProperty(specialty[i])
+		s.servers[serverIP(v.ip)] = v
+	}
+	return s
+}
+
+func TestCategoriesFollowSpecialty(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "FPGA", "H265-HW", "")
+	s.updateBalancers()
+
+	for _, tp := range []taskProperty{CpuPro, "FPGA", "H265-HW"} {
+		if _, ok := s.balancers[tp]; !ok {
+			t.Errorf("no balancer for %s", tp)
+		}
+		if !s.taskQueue.hasCategory(tp) {
+			t.Errorf("no task queue for %s", tp)
+		}
+	}
+	if _, ok := s.balancers[GpuPro]; ok || s.taskQueue.hasCategory(GpuPro) {
+		t.Error("GPU category created without GPU servers")
+	}
+
+	//没有配置Specialty的服务器属于CPU池
+	if n := len(s.poolServers(CpuPro)); n != 2 {
+		t.Errorf("cpu pool has %d servers, want 2", n)
+	}
+}
+
+func TestCategoryRetired(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "FPGA")
+	s.updateBalancers()
+
+	task := NewReqSlbTask()
+	task.TaskType = "FPGA"
+	if err := s.taskQueue.listAdd(task); err != nil {
+		t.Fatal(err)
+	}
+
+	//FPGA服务器从配置中删除，排队中的请求收到任务类型不存在
+	for ip, v := range s.servers {
+		if v.specialty == "FPGA" {
+			delete(s.servers, ip)
+		}
+	}
+	s.updateBalancers()
+
+	if _, ok := s.balancers["FPGA"]; ok || s.taskQueue.hasCategory("FPGA") {
+		t.Fatal("FPGA category not retired")
+	}
+	select {
+	case res := <-*task.ResponseChan:
+		if res.RetCode != retCodeNoTaskServer {
+			t.Errorf("got retCode %d, want %d", res.RetCode, retCodeNoTaskServer)
+		}
+	default:
+		t.Fatal("pending task not answered")
+	}
+
+	task = NewReqSlbTask()
+	task.TaskType = "FPGA"
+	if err := s.taskQueue.listAdd(task); err != errUnknownTaskType {
+		t.Errorf("listAdd on retired type returned %v", err)
+	}
+}
+
+func TestConfigUpdateWhileSelecting(t *testing.T) {
+
+	s := newCategoryTestStrategy("CPU", "CPU")
+	s.updateBalancers()
+
+	//任务队列协程选机的同时，Run协程增加和删除服务器
+	started, stop, done := make(chan bool), make(chan bool), make(chan bool)
+	go func() {
+		defer close(done)
+		close(started)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			r := &ReqSlbTask{TaskType: string(CpuPro), Codec: H264}
+			s.lock.Lock()
+			s.getBackendServer(r)
+			s.lock.Unlock()
+		}
+	}()
+
+	<-started
+	for i := 0; i < 1000; i++ {
+		cfg := &config.Configuration{Backends: []config.Backend{{Host: "192.168.1.1", Weight: 1}}}
+		if i%2 == 0 {
+			cfg.Backends = append(cfg.Backends, config.Backend{Host: "192.168.1.9", Weight: 1})
+		}
+		s.dealUpdateConfig(cfg)
+	}
+	close(stop)
+	<-done
+}
@@ -0,0 +1,105 @@
+package strategy
+
+//服务器过载阈值：CPU、内存、IO等待任意一项超过进入值时服务器暂停分配，
+//全部降到退出值以下才恢复，服务器负载在阈值附近波动时不会反复进出调度池。
+//阈值优先使用服务器自己的配置(slb_svr表)，没有配置时使用配置文件[threshold]中调度池的配置，
+//key为"调度池_cpu_high"、"调度池_cpu_low"等，调度池也没有配置时使用不带调度池前缀的key
+import (
+	"common/utils"
+	"slb/config"
+	"strconv"
+)
+
+//一项指标的阈值，high为0表示不限制，low为0或者不小于high时没有滞回
+type threshold struct {
+	high int //超过此值进入过载
+	low  int //不超过此值退出过载
+}
+
+type thresholds struct {
+	cpu threshold //CPU使用率
+	mem threshold //内存使用率
+	io  threshold //IO等待
+}
+
+//默认只限制CPU，和原来CPU超过90%摘除的行为一致
+var defaultThresholds = thresholds{cpu: threshold{high: 90, low: 80}}
+
+func readThresholdInt(pool taskProperty, key string, def int) int {
+
+	str := utils.ConfigFile.Read_string("threshold", string(pool)+"_"+key, "")
+	if str == "" {
+		str = utils.ConfigFile.Read_string("threshold", key, strconv.Itoa(def))
+	}
+
+	v, err := strconv.Atoi(str)
+	if err != nil || v < 0 || v > 100 {
+		utils.Log.Error("threshold %s_%s:%s is not right, use %d", string(pool), key, str, def)
+		return def
+	}
+	return v
+}
+
+//调度池的阈值
+func readPoolThresholds(pool taskProperty) thresholds {
+
+	defer utils.DealPanic()
+	t := defaultThresholds
+	t.cpu.high = readThresholdInt(pool, "cpu_high", t.cpu.high)
+	t.cpu.low = readThresholdInt(pool, "cpu_low", t.cpu.low)
+	t.mem.high = readThresholdInt(pool, "mem_high", t.mem.high)
+	t.mem.low = readThresholdInt(pool, "mem_low", t.mem.low)
+	t.io.high = readThresholdInt(pool, "io_high", t.io.high)
+	t.io.low = readThresholdInt(pool, "io_low", t.io.low)
+	return t
+}
+
+//服务器的阈值：服务器配置了某项的进入值时整项使用服务器的配置
+func backendThresholds(pool taskProperty, cfg *config.Backend) thresholds {
+	t := readPoolThresholds(pool)
+	if cfg.CpuHigh > 0 {
+		t.cpu = threshold{high: cfg.CpuHigh, low: cfg.CpuLow}
+	}
+	if cfg.MemHigh > 0 {
+		t.mem = threshold{high: cfg.MemHigh, low: cfg.MemLow}
+	}
+	if cfg.IoHigh > 0 {
+		t.io = threshold{high: cfg.IoHigh, low: cfg.IoLow}
+	}
+	return t
+}
+
+func (t threshold) exitLevel() int {
+	if t.low <= 0 || t.low >= t.high {
+		return t.high
+	}
+	return t.low
+}
+
+//是否超过进入值
+func (t threshold) above(v int) bool {
+	return t.high > 0 && v > t.high
+}
+
+//是否已经降到退出值
+func (t threshold) below(v int) bool {
+	return t.high <= 0 || v <= t.exitLevel()
+}
+
+//按新上报的状态更新服务器的过载标志
+func (s *server) updateOverload(state *ServerState) {
+
+	t := s.limits
+	if !s.overloaded {
+		if t.cpu.above(state.CupUtil) || t.mem.above(state.MemUtil) || t.io.above(state.IoWait) {
+			s.overloaded = true
+			utils.Log.Info("server:%s overloaded, cpu:%d mem:%d io:%d", s.ip, state.CupUtil, state.MemUtil, state.IoWait)
+		}
+		return
+	}
+
+	if t.cpu.below(state.CupUtil) && t.mem.below(state.MemUtil) && t.io.below(state.IoWait) {
+		s.overloaded = false
+		utils.Log.Info("server:%s recover from overload, cpu:%d mem:%d io:%d", s.ip, state.CupUtil, state.MemUtil, state.IoWait)
+	}
+}
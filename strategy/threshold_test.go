@@ -0,0 +1,49 @@
+package strategy
+
+import (
+	"slb/config"
+	"testing"
+)
+
+func TestOverloadHysteresis(t *testing.T) {
+
+	s := newTestServers(1)[0]
+	s.limits = thresholds{cpu: threshold{high: 90, low: 70}, mem: threshold{high: 85, low: 75}}
+
+	steps := []struct {
+		state      ServerState
+		overloaded bool
+	}{
+		{ServerState{CupUtil: 85}, false},
+		{ServerState{CupUtil: 91}, true},
+		//在进入值和退出值之间波动，保持过载
+		{ServerState{CupUtil: 85}, true},
+		{ServerState{CupUtil: 89}, true},
+		{ServerState{CupUtil: 70}, false},
+		{ServerState{CupUtil: 85}, false},
+		//内存超限，CPU正常也要等内存降下来
+		{ServerState{MemUtil: 90}, true},
+		{ServerState{MemUtil: 80}, true},
+		{ServerState{MemUtil: 75}, false},
+		//IO没有配置，不限制
+		{ServerState{IoWait: 100}, false},
+	}
+	for i, step := range steps {
+		s.updateOverload(&step.state)
+		if s.overloaded != step.overloaded {
+			t.Fatalf("step %d %+v: overloaded %v, want %v", i, step.state, s.overloaded, step.overloaded)
+		}
+	}
+}
+
+func TestBackendThresholdsOverridePool(t *testing.T) {
+
+	cfg := &config.Backend{MemHigh: 80, MemLow: 60}
+	limits := backendThresholds(CpuPro, cfg)
+	if limits.cpu != defaultThresholds.cpu {
+		t.Errorf("cpu threshold %+v, want pool default %+v", limits.cpu, defaultThresholds.cpu)
+	}
+	if limits.mem != (threshold{high: 80, low: 60}) {
+		t.Errorf("mem threshold %+v, want backend config", limits.mem)
+	}
+}
@@ -1,13 +1,73 @@
 #!/bin/sh
 
+#控制接口(state、config、policy、admin)需要认证，密钥和engine的config.ini中[auth]一致，运行前设置环境变量
+HC_SECRET=${HC_SECRET:?"set HC_SECRET to the healthcheck secret"}
+OP_SECRET=${OP_SECRET:?"set OP_SECRET to the operator secret"}
+HC="-H X-Slb-Caller:healthcheck -H X-Slb-Key:$HC_SECRET"
+OP="-H X-Slb-Caller:operator -H X-Slb-Key:$OP_SECRET"
+
 
 curl -i -d '{"UserID":"1234567","SessonID":"3333","TaskType":"cpu","ReqMode":"selectServer"}' http://10.80.3.173:8081/yfy/select/lb/server
 
+curl -i -d '{"UserID":"1234567","SessonID":"3333","TaskType":"cpu","ReqMode":"selectServer","Codec":"h265"}' http://10.80.3.173:8081/yfy/select/lb/server
+
 
-curl -i -d '{"Ip":"192.168.1.251","CupUtil":91,"IoWait":20,"MemUtil":63, "MemTotal":125,"Down":false}' http://10.80.3.173:8081/yfy/server/state
+curl -i $HC -d '{"Ip":"192.168.1.251","CupUtil":91,"IoWait":20,"MemUtil":63, "MemTotal":125,"Down":false}' http://10.80.3.173:8081/yfy/server/state
 
 
-curl -i -d '{"UserID":"123456","Priority":5,"Ip":"192.168.1.252"}' http://10.80.3.173:8081/yfy/user/policy
+curl -i $OP -d '{"UserID":"123456","Priority":5,"Ip":"192.168.1.252"}' http://10.80.3.173:8081/yfy/user/policy
 
 
 # http://ip:port/yfy/config/file
+
+curl -i -d '{"UserID":"1234567","TaskType":"cpu","ReqMode":"selectServer","Codec":"h264","Lease":true,"LeaseTTL":120}' http://10.80.3.173:8081/yfy/select/lb/server
+
+
+curl -i -d '{"Lease":"<X-Slb-Lease>","Action":"renew","TTL":120}' http://10.80.3.173:8081/yfy/select/lb/lease
+
+
+curl -i -d '{"Lease":"<X-Slb-Lease>","Action":"release"}' http://10.80.3.173:8081/yfy/select/lb/lease
+
+
+curl -i $OP -d '{"Ip":"192.168.1.251","State":"drain"}' http://10.80.3.173:8081/yfy/server/admin
+
+
+curl -i $OP "http://10.80.3.173:8081/yfy/server/admin?ip=192.168.1.251"
+
+
+curl -i $OP -d '{"UserID":"123456","Priority":5,"Rate":10,"Burst":20,"MaxConcurrent":50}' http://10.80.3.173:8081/yfy/user/policy
+
+
+#固定服务器列表，按顺序使用第一台可用的服务器，都不可用时按优先级正常调度(Fallback为fail时回复失败)
+curl -i $OP -d '{"UserID":"123456","Priority":5,"Ips":["192.168.1.251","192.168.1.252"],"Fallback":"schedule"}' http://10.80.3.173:8081/yfy/user/policy
+
+
+#同一优先级内按用户公平排队，Weight为2的用户分发次数是其他用户的两倍
+curl -i $OP -d '{"UserID":"123456","Priority":5,"Weight":2}' http://10.80.3.173:8081/yfy/user/policy
+
+
+#选机结果以JSON回复，不再302跳转，也可以在body中使用"ReqMode":"selectServerJson"
+curl -i -H "Accept: application/json" -d '{"UserID":"123456","TaskType":"CPU"}' http://10.80.3.173:8081/yfy/select/lb/server
+
+
+#解释选机过程，body和选机请求相同，不占用服务器
+curl -i $OP -d '{"UserID":"123456","TaskType":"CPU","Codec":"H264"}' http://10.80.3.173:8081/yfy/select/lb/explain
+
+
+#只读查询全部服务器和调度池的快照，?ip=xxx 只查询一台服务器
+curl -i $OP "http://10.80.3.173:8081/yfy/server/list"
+
+
+#查询、分页列出和删除用户策略，PUT和POST一样添加或者修改
+curl -i $OP "http://10.80.3.173:8081/yfy/user/policy?userid=123456"
+curl -i $OP "http://10.80.3.173:8081/yfy/user/policy?offset=0&limit=100"
+curl -i $OP -X PUT -d '{"UserID":"123456","Priority":9}' http://10.80.3.173:8081/yfy/user/policy
+curl -i $OP -X DELETE "http://10.80.3.173:8081/yfy/user/policy?userid=123456"
+
+
+#HMAC签名：hex(HMAC-SHA256(密钥, 方法\n路径\n查询参数\n时间戳\n随机数\nbody))，查询参数按参数名排序，没有时为空；随机数每次请求重新生成
+BODY='{"Ip":"192.168.1.251","CupUtil":50,"IoWait":5,"MemUtil":40,"MemTotal":125,"Down":false}'
+TS=$(date +%s)
+NONCE=$(openssl rand -hex 16)
+SIG=$(printf 'POST\n/yfy/server/state\n\n%s\n%s\n%s' "$TS" "$NONCE" "$BODY" | openssl dgst -sha256 -hmac "$HC_SECRET" | sed 's/^.* //')
+curl -i -H "X-Slb-Caller: healthcheck" -H "X-Slb-Timestamp: $TS" -H "X-Slb-Nonce: $NONCE" -H "X-Slb-Signature: $SIG" -d "$BODY" http://10.80.3.173:8081/yfy/server/state